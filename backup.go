@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lando-ke/card-api/config"
+	"github.com/lando-ke/card-api/database"
+)
+
+func backup(cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: card-api backup <path>")
+	}
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	if err := database.Backup(db, args[0]); err != nil {
+		return err
+	}
+
+	fmt.Printf("backed up %s to %s\n", cfg.Database.Path, args[0])
+	return nil
+}
+
+func restore(cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: card-api restore <path>")
+	}
+
+	version, err := database.Restore(cfg.Database, args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("restored %s from %s at schema version %d\n", cfg.Database.Path, args[0], version)
+	if version < database.LatestVersion() {
+		fmt.Printf("run `card-api migrate up` to migrate it to version %d\n", database.LatestVersion())
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lando-ke/card-api/encryption"
+)
+
+const (
+	// StorageRows stores every card of a deck as a row of its own.
+	StorageRows = "rows"
+	// StorageCompact stores the cards of a deck in one encoded column.
+	StorageCompact = "compact"
+)
+
+// Config holds the settings of the server. Every setting can be overridden
+// with a CARD_API_* environment variable.
+type Config struct {
+	// Addr is the address the HTTP server listens on.
+	Addr string
+	// AutoMigrate applies pending schema migrations when the server starts.
+	// Without it the server refuses to start on an outdated schema and the
+	// migrations are run with the migrate command instead.
+	AutoMigrate bool
+	// DeckIdleTTL expires decks created without a TTL once they have not
+	// changed for this long. Zero keeps them forever.
+	DeckIdleTTL time.Duration
+	// CleanupInterval is how often expired decks are deleted. Zero turns the
+	// cleanup off.
+	CleanupInterval time.Duration
+	// CleanupBatchSize is the number of decks deleted per statement.
+	CleanupBatchSize int
+	// UndoDepth is the number of recent operations of a deck that can be
+	// undone. Zero turns undo off.
+	UndoDepth int
+	// AdminToken is the bearer token of the /admin endpoints. Without it
+	// they are not served.
+	AdminToken string
+	// Storage is the layout new decks are stored in, StorageRows or
+	// StorageCompact. Decks already stored keep their layout.
+	Storage string
+	// Encryption holds the keys the cards of decks are encrypted with at
+	// rest. It is nil, and nothing is encrypted, unless keys are configured
+	// as a comma separated list of id:key pairs with 32 byte base64 keys.
+	// New data is encrypted with the first key; the others only decrypt.
+	Encryption *encryption.Keyring
+	// DeckActors runs the operations on each active deck through a
+	// goroutine of its own that keeps the deck in memory and writes its
+	// changes to the database behind the requests. Only one server may use
+	// the database while it is on.
+	DeckActors bool
+	// ActorMailboxSize is the number of requests a deck queues for its
+	// actor before it answers 503 Service Unavailable.
+	ActorMailboxSize int
+	// ActorIdleTimeout is how long the actor of a deck waits for requests
+	// before it stops.
+	ActorIdleTimeout time.Duration
+	// DeckCacheSize is the number of decks kept in memory to answer reads.
+	// Zero turns the cache off.
+	DeckCacheSize int
+	// BackupDir is the directory POST /admin/backup writes backups to.
+	// Without it the endpoint is off.
+	BackupDir string
+	Database  DatabaseConfig
+}
+
+type DatabaseConfig struct {
+	// Driver selects the SQLite driver: "sqlite" uses the CGO driver and
+	// "sqlite-purego" a pure Go one. Empty picks "sqlite" when the binary
+	// is built with CGO and "sqlite-purego" otherwise.
+	Driver string
+	// Path is the database file, or ":memory:" for a database that lives as
+	// long as the process.
+	Path string
+	// JournalMode sets the SQLite journal mode, e.g. "WAL". Empty keeps the
+	// SQLite default.
+	JournalMode string
+	// BusyTimeout is how long a connection waits for a locked database.
+	BusyTimeout time.Duration
+}
+
+func Default() Config {
+	return Config{
+		Addr:             ":8080",
+		AutoMigrate:      true,
+		CleanupInterval:  time.Minute,
+		CleanupBatchSize: 500,
+		UndoDepth:        10,
+		Storage:          StorageRows,
+		ActorMailboxSize: 64,
+		ActorIdleTimeout: time.Minute,
+		Database: DatabaseConfig{
+			Path:        "card-api.db",
+			BusyTimeout: 5 * time.Second,
+		},
+	}
+}
+
+// Load returns the default configuration overridden by the environment.
+func Load() (Config, error) {
+	cfg := Default()
+
+	setString(&cfg.Addr, "CARD_API_ADDR")
+	if err := setBool(&cfg.AutoMigrate, "CARD_API_AUTO_MIGRATE"); err != nil {
+		return Config{}, err
+	}
+	if err := setDuration(&cfg.DeckIdleTTL, "CARD_API_DECK_IDLE_TTL"); err != nil {
+		return Config{}, err
+	}
+	if err := setDuration(&cfg.CleanupInterval, "CARD_API_CLEANUP_INTERVAL"); err != nil {
+		return Config{}, err
+	}
+	if err := setInt(&cfg.CleanupBatchSize, "CARD_API_CLEANUP_BATCH_SIZE"); err != nil {
+		return Config{}, err
+	}
+	if cfg.CleanupBatchSize < 1 {
+		return Config{}, fmt.Errorf("CARD_API_CLEANUP_BATCH_SIZE must be at least 1")
+	}
+	if err := setInt(&cfg.UndoDepth, "CARD_API_UNDO_DEPTH"); err != nil {
+		return Config{}, err
+	}
+	if cfg.UndoDepth < 0 {
+		return Config{}, fmt.Errorf("CARD_API_UNDO_DEPTH must not be negative")
+	}
+	setString(&cfg.AdminToken, "CARD_API_ADMIN_TOKEN")
+	setString(&cfg.Storage, "CARD_API_STORAGE")
+	if cfg.Storage != StorageRows && cfg.Storage != StorageCompact {
+		return Config{}, fmt.Errorf("CARD_API_STORAGE must be %q or %q", StorageRows, StorageCompact)
+	}
+	keys, err := encryption.ParseKeys(os.Getenv("CARD_API_ENCRYPTION_KEYS"))
+	if err != nil {
+		return Config{}, fmt.Errorf("CARD_API_ENCRYPTION_KEYS: %w", err)
+	}
+	cfg.Encryption = keys
+	if err := setBool(&cfg.DeckActors, "CARD_API_DECK_ACTORS"); err != nil {
+		return Config{}, err
+	}
+	if err := setInt(&cfg.ActorMailboxSize, "CARD_API_ACTOR_MAILBOX_SIZE"); err != nil {
+		return Config{}, err
+	}
+	if cfg.ActorMailboxSize < 1 {
+		return Config{}, fmt.Errorf("CARD_API_ACTOR_MAILBOX_SIZE must be at least 1")
+	}
+	if err := setDuration(&cfg.ActorIdleTimeout, "CARD_API_ACTOR_IDLE_TIMEOUT"); err != nil {
+		return Config{}, err
+	}
+	if cfg.ActorIdleTimeout <= 0 {
+		return Config{}, fmt.Errorf("CARD_API_ACTOR_IDLE_TIMEOUT must be positive")
+	}
+	if err := setInt(&cfg.DeckCacheSize, "CARD_API_DECK_CACHE_SIZE"); err != nil {
+		return Config{}, err
+	}
+	if cfg.DeckCacheSize < 0 {
+		return Config{}, fmt.Errorf("CARD_API_DECK_CACHE_SIZE must not be negative")
+	}
+	setString(&cfg.BackupDir, "CARD_API_BACKUP_DIR")
+	setString(&cfg.Database.Driver, "CARD_API_DB_DRIVER")
+	setString(&cfg.Database.Path, "CARD_API_DB_PATH")
+	setString(&cfg.Database.JournalMode, "CARD_API_DB_JOURNAL_MODE")
+	if err := setDuration(&cfg.Database.BusyTimeout, "CARD_API_DB_BUSY_TIMEOUT"); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+func setString(value *string, name string) {
+	if v, ok := os.LookupEnv(name); ok {
+		*value = v
+	}
+}
+
+func setInt(value *int, name string) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	*value = i
+	return nil
+}
+
+func setBool(value *bool, name string) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	*value = b
+	return nil
+}
+
+func setDuration(value *time.Duration, name string) error {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	*value = d
+	return nil
+}
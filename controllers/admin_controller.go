@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lando-ke/card-api/database"
+	"github.com/lando-ke/card-api/fsck"
+	"github.com/lando-ke/card-api/store"
+)
+
+// AdminController serves the maintenance endpoints. Every request must carry
+// the admin token as a bearer token.
+type AdminController struct {
+	checker *fsck.Checker
+	token   string
+	// cache is the deck cache of the server, or nil when it has none.
+	cache *store.CachedDeckStore
+	// actors holds the decks of the server in memory, or is nil when deck
+	// actors are off.
+	actors *store.ActorDeckStore
+	// backups writes backups of the database, or is nil when they are off.
+	backups *database.Backups
+}
+
+func NewAdminController(checker *fsck.Checker, token string, cache *store.CachedDeckStore, actors *store.ActorDeckStore, backups *database.Backups) *AdminController {
+	return &AdminController{checker: checker, token: token, cache: cache, actors: actors, backups: backups}
+}
+
+// Authorize rejects requests without the admin token.
+func (ac *AdminController) Authorize(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || ac.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(ac.token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	c.Next()
+}
+
+// Check reports the inconsistencies in the deck database.
+func (ac *AdminController) Check(c *gin.Context) {
+	report, err := ac.checker.Check()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking decks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// Repair repairs the inconsistencies in the deck database and reports them.
+// It refuses while deck actors are on, as they would keep serving, and
+// writing back, the decks as they were before the repair.
+func (ac *AdminController) Repair(c *gin.Context) {
+	if ac.actors != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Decks are held by deck actors; stop the server and run card-api fsck --repair"})
+		return
+	}
+
+	report, err := ac.checker.Repair()
+	// Repairs change decks behind the back of the cache.
+	if ac.cache != nil {
+		ac.cache.Purge()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error repairing decks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// CacheStats reports the use of the deck cache.
+func (ac *AdminController) CacheStats(c *gin.Context) {
+	if ac.cache == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck cache is off"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ac.cache.Stats())
+}
+
+// Backup writes a backup of the database to the backup directory, with every
+// change the deck actors have answered.
+func (ac *AdminController) Backup(c *gin.Context) {
+	if ac.backups == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backups are off"})
+		return
+	}
+
+	// The backup is only complete once the actors have written what they
+	// hold.
+	if ac.actors != nil {
+		if err := ac.actors.Flush(); err != nil {
+			if !respondBusy(c, err) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error writing the changes of decks"})
+			}
+			return
+		}
+	}
+
+	backup, err := ac.backups.Create()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error backing up the database"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, backup)
+}
@@ -1,19 +1,30 @@
 package controllers
 
 import (
-	"gorm.io/gorm"
+	"errors"
 	"net/http"
 	"strings"
 	"strconv"
-	"fmt"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/store"
 	"github.com/lando-ke/card-api/utils"
 )
 
+// DefaultUndoDepth is the number of recent operations of a deck that can be
+// undone unless WithUndoDepth says otherwise.
+const DefaultUndoDepth = 10
+
+// OwnerTokenHeader is the request header that carries the owner token of a
+// deck, which shows the owner of a models.RevealOwnerOnly deck its cards.
+const OwnerTokenHeader = "X-Owner-Token"
+
 type DeckController struct {
-	db *gorm.DB
+	store     store.DeckStore
+	idleTTL   time.Duration
+	undoDepth int
 }
 
 type DeckResponse struct {
@@ -21,6 +32,12 @@ type DeckResponse struct {
 	Shuffled  bool           `json:"shuffled"`
 	Remaining int            `json:"remaining"`
 	Cards     []CardResponse `json:"cards"`
+	ExpiresAt *time.Time     `json:"expires_at,omitempty"`
+	Version   int            `json:"version"`
+	// RevealPolicy says how much of the remaining cards Cards shows.
+	RevealPolicy string `json:"reveal_policy"`
+	// OwnerToken is only sent when a models.RevealOwnerOnly deck is created.
+	OwnerToken string `json:"owner_token,omitempty"`
 }
 
 type CardResponse struct {
@@ -29,6 +46,53 @@ type CardResponse struct {
 	Code  string `json:"code"`
 }
 
+// CreateDeckRequest is the optional JSON body of CreateDeck. It lists the
+// cards of the deck in draw order; with Strict set the deck is created in
+// exactly that order or not at all.
+type CreateDeckRequest struct {
+	Cards    []string `json:"cards"`
+	Shuffled bool     `json:"shuffled"`
+	Strict   bool     `json:"strict"`
+	// TTL is a duration such as "30m" after which the deck expires.
+	TTL string `json:"ttl"`
+	// RevealPolicy is one of models.RevealPolicies.
+	RevealPolicy string `json:"reveal_policy"`
+}
+
+type HistoryResponse struct {
+	DeckID  string              `json:"deck_id"`
+	History []OperationResponse `json:"history"`
+}
+
+type OperationResponse struct {
+	EventNo     int       `json:"event_no"`
+	Method      string    `json:"method"`
+	Count       int       `json:"count"`
+	SeedHash    string    `json:"seed_hash,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Holder      string    `json:"holder,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// SnapshotRequest is the JSON body of SaveSnapshot.
+type SnapshotRequest struct {
+	Name string `json:"name"`
+}
+
+type SnapshotResponse struct {
+	Name        string    `json:"name"`
+	Version     int       `json:"version"`
+	Shuffled    bool      `json:"shuffled"`
+	Remaining   int       `json:"remaining"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type SnapshotsResponse struct {
+	DeckID    string             `json:"deck_id"`
+	Snapshots []SnapshotResponse `json:"snapshots"`
+}
+
 func cardModelToResponse(card models.Card) CardResponse {
 	return CardResponse{
 		Value: card.Value,
@@ -37,13 +101,198 @@ func cardModelToResponse(card models.Card) CardResponse {
 	}
 }
 
-func NewDeckController(db *gorm.DB) *DeckController {
-	return &DeckController{db}
+func deckModelToResponse(deck models.Deck) DeckResponse {
+	response := DeckResponse{
+		DeckID:    deck.DeckID,
+		Shuffled:  deck.Shuffled,
+		Remaining: deck.Remaining,
+		ExpiresAt: deck.ExpiresAt,
+		Version:   deck.Version,
+		// Decks created before there were policies are shown in full.
+		RevealPolicy: deck.RevealPolicy,
+	}
+	if response.RevealPolicy == "" {
+		response.RevealPolicy = models.RevealFull
+	}
+
+	for _, card := range deck.Cards {
+		response.Cards = append(response.Cards, cardModelToResponse(card))
+	}
+
+	return response
+}
+
+// revealDeck describes deck with only the remaining cards its reveal policy
+// lets a client holding token see.
+func revealDeck(deck models.Deck, token string) DeckResponse {
+	deck.Cards = utils.RevealedCards(deck, token)
+	response := deckModelToResponse(deck)
+	if response.Cards == nil {
+		response.Cards = []CardResponse{}
+	}
+	return response
+}
+
+// ownerToken returns the owner token sent with the request, if any.
+func ownerToken(c *gin.Context) string {
+	return c.GetHeader(OwnerTokenHeader)
+}
+
+// operationModelToResponse describes op. Its fingerprint is left out unless
+// showOrder is set, as it tells which of the possible orders the deck is in.
+func operationModelToResponse(op models.DeckOperation, showOrder bool) OperationResponse {
+	if !showOrder {
+		op.Fingerprint = ""
+	}
+	return OperationResponse{
+		EventNo:     op.Seq,
+		Method:      op.Method,
+		Count:       op.Count,
+		SeedHash:    op.SeedHash,
+		Fingerprint: op.Fingerprint,
+		Holder:      op.Holder,
+		Timestamp:   op.CreatedAt,
+	}
+}
+
+// snapshotModelToResponse describes snapshot, with its fingerprint only when
+// showOrder is set.
+func snapshotModelToResponse(snapshot models.DeckSnapshot, showOrder bool) SnapshotResponse {
+	if !showOrder {
+		snapshot.Fingerprint = ""
+	}
+	return SnapshotResponse{
+		Name:        snapshot.Name,
+		Version:     snapshot.Version,
+		Shuffled:    snapshot.Shuffled,
+		Remaining:   snapshot.Remaining,
+		Fingerprint: snapshot.Fingerprint,
+		CreatedAt:   snapshot.CreatedAt,
+	}
+}
+
+// setETag sends the version of deck as the ETag of the response.
+func setETag(c *gin.Context, deck models.Deck) {
+	c.Header("ETag", strconv.Quote(strconv.Itoa(deck.Version)))
+}
+
+// ifMatch makes fn conditional on the If-Match header of the request, which
+// lists the ETags of the deck versions the client expects to change. Without
+// the header, or with "*", fn is returned unchanged.
+func ifMatch(c *gin.Context, fn store.UpdateFunc) store.UpdateFunc {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return fn
+	}
+
+	versions := []int{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if version, err := strconv.Atoi(strings.Trim(tag, `"`)); err == nil {
+			versions = append(versions, version)
+		}
+	}
+
+	return store.IfVersion(versions, fn)
+}
+
+// respondVersionMismatch answers a request whose If-Match no longer matches
+// the deck. It reports whether err was such a mismatch.
+func respondVersionMismatch(c *gin.Context, err error) bool {
+	var mismatch *store.VersionMismatchError
+	if !errors.As(err, &mismatch) {
+		return false
+	}
+
+	c.Header("ETag", strconv.Quote(strconv.Itoa(mismatch.Version)))
+	c.JSON(http.StatusPreconditionFailed, gin.H{"message": mismatch.Error()})
+	return true
+}
+
+// respondBusy answers a request for a deck that has too many requests
+// queued already.
+func respondBusy(c *gin.Context, err error) bool {
+	if !errors.Is(err, store.ErrDeckBusy) {
+		return false
+	}
+
+	c.Header("Retry-After", "1")
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	return true
+}
+
+// Option configures a DeckController.
+type Option func(*DeckController)
+
+// WithIdleTTL makes decks without a TTL of their own expire after being idle
+// for ttl.
+func WithIdleTTL(ttl time.Duration) Option {
+	return func(dc *DeckController) {
+		dc.idleTTL = ttl
+	}
+}
+
+// WithUndoDepth limits undo to the depth most recent operations of a deck.
+// Zero turns undo off.
+func WithUndoDepth(depth int) Option {
+	return func(dc *DeckController) {
+		dc.undoDepth = depth
+	}
+}
+
+func NewDeckController(s store.DeckStore, opts ...Option) *DeckController {
+	dc := &DeckController{store: s, undoDepth: DefaultUndoDepth}
+	for _, opt := range opts {
+		opt(dc)
+	}
+	return dc
 }
 
 func (dc *DeckController) CreateDeck(c *gin.Context) {
 	shuffled := c.Query("shuffled") == "true"
 	cardsParam := c.Query("cards")
+	ttlParam := c.Query("ttl")
+	revealPolicy := c.DefaultQuery("reveal_policy", models.RevealFull)
+
+	var request CreateDeckRequest
+	if c.Request.ContentLength != 0 && c.ContentType() == gin.MIMEJSON {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request body"})
+			return
+		}
+		if len(request.Cards) > 0 {
+			cardsParam = strings.Join(request.Cards, ",")
+		}
+		if request.TTL != "" {
+			ttlParam = request.TTL
+		}
+		if request.RevealPolicy != "" {
+			revealPolicy = request.RevealPolicy
+		}
+		shuffled = shuffled || request.Shuffled
+	}
+
+	if !models.ValidRevealPolicy(revealPolicy) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "reveal_policy must be one of " + strings.Join(models.RevealPolicies, ", ")})
+		return
+	}
+	opts := utils.DeckOptions{Shuffled: shuffled, RevealPolicy: revealPolicy}
+	if revealPolicy == models.RevealOwnerOnly {
+		opts.OwnerToken = utils.NewOwnerToken()
+	}
+	if ttlParam != "" {
+		ttl, err := time.ParseDuration(ttlParam)
+		if err != nil || ttl <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ttl parameter"})
+			return
+		}
+		opts.TTL = ttl
+	}
+
+	if request.Strict {
+		dc.createStackedDeck(c, request, opts)
+		return
+	}
 
 	if cardsParam != "" {
 		invalidCards := utils.ValidateCardsParam(cardsParam)
@@ -53,54 +302,129 @@ func (dc *DeckController) CreateDeck(c *gin.Context) {
 		}
 	}
 
-	deck, err := utils.NewDeck(dc.db, shuffled, cardsParam)
+	deck, err := utils.CreateDeck(dc.store, utils.CardsFromParam(cardsParam), opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating deck"})
 		return
 	}
 
-	response := DeckResponse{
-		DeckID:    deck.DeckID,
-		Shuffled:  deck.Shuffled,
-		Remaining: deck.Remaining,
+	setETag(c, deck)
+	c.JSON(http.StatusOK, createdDeckResponse(deck, opts.OwnerToken))
+}
+
+// createdDeckResponse describes a deck that was just created, handing its
+// owner token, if it has one, to the client that created it.
+func createdDeckResponse(deck models.Deck, token string) DeckResponse {
+	response := revealDeck(deck, token)
+	response.OwnerToken = token
+	return response
+}
+
+// createStackedDeck creates a deck in exactly the order given by the request,
+// refusing the whole request if any card code cannot be used.
+func (dc *DeckController) createStackedDeck(c *gin.Context, request CreateDeckRequest, opts utils.DeckOptions) {
+	if opts.Shuffled {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "a strict deck cannot be shuffled"})
+		return
 	}
 
-	for _, card := range deck.Cards {
-		response.Cards = append(response.Cards, cardModelToResponse(card))
+	if len(request.Cards) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "a strict deck needs at least one card"})
+		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	cards, invalidCards := utils.CreateStackedDeck(request.Cards)
+	if len(invalidCards) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid cards values: " + strings.Join(invalidCards, ", ")})
+		return
+	}
+
+	deck, err := utils.CreateDeck(dc.store, cards, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating deck"})
+		return
+	}
+
+	setETag(c, deck)
+	c.JSON(http.StatusOK, createdDeckResponse(deck, opts.OwnerToken))
+}
+
+// findDeck loads a deck that has not expired. When there is no such deck it
+// writes the error response and returns false.
+func (dc *DeckController) findDeck(c *gin.Context, deckID string) (models.Deck, bool) {
+	deck, err := dc.store.Get(deckID)
+	if errors.Is(err, store.ErrDeckNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return models.Deck{}, false
+	}
+	if respondBusy(c, err) {
+		return models.Deck{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading cards"})
+		return models.Deck{}, false
+	}
+	if deck.Expired(time.Now(), dc.idleTTL) {
+		c.JSON(http.StatusGone, gin.H{"error": "Deck expired"})
+		return models.Deck{}, false
+	}
+
+	return deck, true
 }
 
 func (dc *DeckController) OpenDeck(c *gin.Context) {
 	deckID := c.Param("deck_id")
 
-	var deck models.Deck
-	if err := dc.db.Where("deck_id = ?", deckID).First(&deck).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+	deck, ok := dc.findDeck(c, deckID)
+	if !ok {
 		return
 	}
 
-	var cards []models.Card
-	if err := dc.db.Model(&models.Card{}).Where("deck_id = ? AND deleted_at IS NULL", deckID).Find(&cards).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading cards"})
+	if at := c.Query("at"); at != "" {
+		dc.openDeckAt(c, deck, at)
 		return
 	}
 
-	// Convert cards to CardResponse
-	cardResponses := []CardResponse{}
-	for _, card := range cards {
-		cardResponses = append(cardResponses, cardModelToResponse(card))
+	setETag(c, deck)
+	c.JSON(http.StatusOK, revealDeck(deck, ownerToken(c)))
+}
+
+// openDeckAt responds with deck as it was at a point in its history, rebuilt
+// from the deck's events.
+func (dc *DeckController) openDeckAt(c *gin.Context, deck models.Deck, at string) {
+	events, err := dc.store.History(deck.DeckID)
+	if errors.Is(err, store.ErrDeckNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	if respondBusy(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading deck history"})
+		return
 	}
 
-	response := DeckResponse{
-		DeckID:    deck.DeckID,
-		Shuffled:  deck.Shuffled,
-		Remaining: deck.Remaining,
-		Cards:     cardResponses,
+	events, err = utils.EventsAt(events, at)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	past, err := utils.ReplayDeck(deck.DeckID, events)
+	if errors.Is(err, utils.ErrCannotReplay) {
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error rebuilding deck"})
+		return
+	}
+	past.ExpiresAt = deck.ExpiresAt
+	past.RevealPolicy = deck.RevealPolicy
+	past.OwnerTokenHash = deck.OwnerTokenHash
+
+	c.JSON(http.StatusOK, revealDeck(past, ownerToken(c)))
 }
 
 func (dc *DeckController) DrawCard(c *gin.Context) {
@@ -113,24 +437,38 @@ func (dc *DeckController) DrawCard(c *gin.Context) {
 		return
 	}
 
-	var deck models.Deck
-	if err := dc.db.Where("deck_id = ?", deckID).First(&deck).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+	// holder names who the drawn cards go to, such as a player.
+	holder := c.Query("holder")
+	if len(holder) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid holder parameter"})
 		return
 	}
 
-	if count > deck.Remaining {
-    	c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("not enough cards in deck, only %d remaining", deck.Remaining)})
-    	return
+	if _, ok := dc.findDeck(c, deckID); !ok {
+		return
 	}
 
-	var cards []models.Card
-	if err := dc.db.Where("deck_id = ?", deckID).Order("id ASC").Limit(count).Find(&cards).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading cards"})
+	var drawnCards []models.Card
+	deck, err := dc.store.Update(deckID, ifMatch(c, store.HeldBy(holder, store.DrawFunc(count, &drawnCards))))
+	var notEnoughCards *store.NotEnoughCardsError
+	if errors.Is(err, store.ErrDeckNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	if respondBusy(c, err) {
+		return
+	}
+	if respondVersionMismatch(c, err) {
+		return
+	}
+	if errors.As(err, &notEnoughCards) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": notEnoughCards.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error drawing cards"})
 		return
 	}
-
-	drawnCards := cards[:count]
 
 	// Convert drawnCards to CardResponse
 	drawnCardResponses := []CardResponse{}
@@ -138,17 +476,348 @@ func (dc *DeckController) DrawCard(c *gin.Context) {
 		drawnCardResponses = append(drawnCardResponses, cardModelToResponse(card))
 	}
 
-	// Update the remaining card count
-	deck.Remaining = deck.Remaining - count
-	if err := dc.db.Model(&models.Deck{}).Where("deck_id = ?", deckID).Update("remaining", deck.Remaining).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating remaining card count"})
+	setETag(c, deck)
+	c.JSON(http.StatusOK, gin.H{"cards": drawnCardResponses})
+}
+
+func (dc *DeckController) ShuffleDeck(c *gin.Context) {
+	deckID := c.Param("deck_id")
+
+	var opts utils.ShuffleOptions
+	var err error
+	if top := c.Query("top"); top != "" {
+		if opts.Top, err = strconv.Atoi(top); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid top parameter"})
+			return
+		}
+	}
+	if bottom := c.Query("bottom"); bottom != "" {
+		if opts.Bottom, err = strconv.Atoi(bottom); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bottom parameter"})
+			return
+		}
+	}
+	if pinned := c.Query("pinned"); pinned != "" {
+		opts.Pinned = strings.Split(pinned, ",")
+	}
+
+	if _, ok := dc.findDeck(c, deckID); !ok {
 		return
 	}
 
-	// Soft delete the drawn cards
-	for _, card := range drawnCards {
-		dc.db.Delete(&models.Card{}, card.ID)
+	deck, err := dc.store.Update(deckID, ifMatch(c, utils.ShuffleFunc(opts)))
+	if errors.Is(err, store.ErrDeckNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	if respondBusy(c, err) {
+		return
+	}
+	if respondVersionMismatch(c, err) {
+		return
+	}
+	if errors.Is(err, utils.ErrInvalidShuffle) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error shuffling deck"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"cards": drawnCardResponses})
+	setETag(c, deck)
+	c.JSON(http.StatusOK, revealDeck(deck, ownerToken(c)))
+}
+
+// UndoDeck reverses the most recent operations of a deck, one unless the
+// steps parameter says otherwise.
+func (dc *DeckController) UndoDeck(c *gin.Context) {
+	deckID := c.Param("deck_id")
+
+	steps := 1
+	if stepsParam := c.Query("steps"); stepsParam != "" {
+		var err error
+		if steps, err = strconv.Atoi(stepsParam); err != nil || steps < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid steps parameter"})
+			return
+		}
+	}
+
+	current, ok := dc.findDeck(c, deckID)
+	if !ok {
+		return
+	}
+	// Drawing again after an undo would deal the hidden cards face up.
+	if !utils.OrderVisible(current, ownerToken(c)) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "the reveal policy of the deck does not allow undoing"})
+		return
+	}
+
+	history, err := dc.store.History(deckID)
+	if errors.Is(err, store.ErrDeckNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	if respondBusy(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading deck history"})
+		return
+	}
+
+	undo, err := utils.UndoFunc(history, current.Version, steps, dc.undoDepth)
+	if errors.Is(err, utils.ErrInvalidUndo) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if errors.Is(err, utils.ErrCannotReplay) {
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error undoing deck operations"})
+		return
+	}
+
+	deck, err := dc.store.Update(deckID, ifMatch(c, undo))
+	if errors.Is(err, store.ErrDeckNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	if respondBusy(c, err) {
+		return
+	}
+	if respondVersionMismatch(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error undoing deck operations"})
+		return
+	}
+
+	setETag(c, deck)
+	c.JSON(http.StatusOK, revealDeck(deck, ownerToken(c)))
+}
+
+func (dc *DeckController) History(c *gin.Context) {
+	deckID := c.Param("deck_id")
+
+	deck, ok := dc.findDeck(c, deckID)
+	if !ok {
+		return
+	}
+	showOrder := utils.OrderVisible(deck, ownerToken(c))
+
+	operations, err := dc.store.History(deckID)
+	if errors.Is(err, store.ErrDeckNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	if respondBusy(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading deck history"})
+		return
+	}
+
+	response := HistoryResponse{
+		DeckID:  deckID,
+		History: []OperationResponse{},
+	}
+
+	for _, op := range operations {
+		response.History = append(response.History, operationModelToResponse(op, showOrder))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SaveSnapshot saves the current state of a deck under the name given in the
+// request body.
+func (dc *DeckController) SaveSnapshot(c *gin.Context) {
+	deckID := c.Param("deck_id")
+
+	var request SnapshotRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request body"})
+		return
+	}
+
+	deck, ok := dc.findDeck(c, deckID)
+	if !ok {
+		return
+	}
+
+	snapshot, err := utils.SaveSnapshot(dc.store, deckID, request.Name)
+	if errors.Is(err, utils.ErrInvalidSnapshotName) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if errors.Is(err, store.ErrDeckNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	if respondBusy(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving snapshot"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, snapshotModelToResponse(snapshot, utils.OrderVisible(deck, ownerToken(c))))
+}
+
+func (dc *DeckController) ListSnapshots(c *gin.Context) {
+	deckID := c.Param("deck_id")
+
+	deck, ok := dc.findDeck(c, deckID)
+	if !ok {
+		return
+	}
+	showOrder := utils.OrderVisible(deck, ownerToken(c))
+
+	snapshots, err := dc.store.Snapshots(deckID)
+	if errors.Is(err, store.ErrDeckNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	if respondBusy(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading snapshots"})
+		return
+	}
+
+	response := SnapshotsResponse{
+		DeckID:    deckID,
+		Snapshots: []SnapshotResponse{},
+	}
+
+	for _, snapshot := range snapshots {
+		response.Snapshots = append(response.Snapshots, snapshotModelToResponse(snapshot, showOrder))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RestoreSnapshot puts a deck back in the state of one of its snapshots.
+func (dc *DeckController) RestoreSnapshot(c *gin.Context) {
+	deckID := c.Param("deck_id")
+
+	current, ok := dc.findDeck(c, deckID)
+	if !ok {
+		return
+	}
+	// As with undo, restoring and drawing again would give the order away.
+	if !utils.OrderVisible(current, ownerToken(c)) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "the reveal policy of the deck does not allow restoring snapshots"})
+		return
+	}
+
+	snapshot, err := dc.store.Snapshot(deckID, c.Param("name"))
+	if errors.Is(err, store.ErrSnapshotNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
+		return
+	}
+	if errors.Is(err, store.ErrDeckNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	if respondBusy(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading snapshot"})
+		return
+	}
+
+	restore, err := utils.RestoreFunc(snapshot)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error restoring snapshot"})
+		return
+	}
+
+	deck, err := dc.store.Update(deckID, ifMatch(c, restore))
+	if errors.Is(err, store.ErrDeckNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	if respondBusy(c, err) {
+		return
+	}
+	if respondVersionMismatch(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error restoring snapshot"})
+		return
+	}
+
+	setETag(c, deck)
+	c.JSON(http.StatusOK, revealDeck(deck, ownerToken(c)))
+}
+
+// ExportDeck responds with the full state of a deck in the portable export
+// format. As that gives away the order of the deck, only decks whose reveal
+// policy shows the order to the client can be exported.
+func (dc *DeckController) ExportDeck(c *gin.Context) {
+	deckID := c.Param("deck_id")
+
+	deck, ok := dc.findDeck(c, deckID)
+	if !ok {
+		return
+	}
+	if !utils.OrderVisible(deck, ownerToken(c)) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "the reveal policy of the deck does not allow exporting it"})
+		return
+	}
+
+	doc, err := utils.ExportDeck(dc.store, deckID)
+	if errors.Is(err, store.ErrDeckNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deck not found"})
+		return
+	}
+	if respondBusy(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error exporting deck"})
+		return
+	}
+
+	c.JSON(http.StatusOK, doc)
+}
+
+// ImportDeck creates a deck from a document written by ExportDeck.
+func (dc *DeckController) ImportDeck(c *gin.Context) {
+	var doc utils.DeckExport
+	if err := c.ShouldBindJSON(&doc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request body"})
+		return
+	}
+
+	token := ""
+	if doc.RevealPolicy == models.RevealOwnerOnly {
+		token = utils.NewOwnerToken()
+	}
+
+	deck, err := utils.ImportDeck(dc.store, doc, token)
+	if errors.Is(err, utils.ErrInvalidImport) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if respondBusy(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error importing deck"})
+		return
+	}
+
+	setETag(c, deck)
+	c.JSON(http.StatusCreated, createdDeckResponse(deck, token))
 }
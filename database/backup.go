@@ -0,0 +1,184 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lando-ke/card-api/config"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidBackup is returned when restoring a file that is not a usable
+// backup of the deck database.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// Backup writes a consistent copy of the database to path with VACUUM INTO,
+// which reads the database in a single transaction and so can run while the
+// database is being written. The copy is written next to path and renamed
+// into place, so that path never holds a partial backup. An existing file at
+// path is not overwritten.
+func Backup(db *gorm.DB, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	tmp := tempPath(path)
+	if err := db.Exec("VACUUM INTO ?", tmp).Error; err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Restore replaces the database of cfg with the backup at path. It checks
+// the backup first: its schema must be known to this build and it must pass
+// SQLite's integrity check. A backup at an older schema version is restored
+// as it is and migrated like any other database. Restore returns the schema
+// version of the backup. The server must not be running while the database
+// is restored.
+func Restore(cfg config.DatabaseConfig, path string) (int, error) {
+	if isMemory(cfg.Path) {
+		return 0, fmt.Errorf("cannot restore into an in-memory database")
+	}
+
+	version, err := checkBackup(cfg, path)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := tempPath(cfg.Path)
+	if err := copyFile(path, tmp); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	// A journal left by the old database would be applied to the restored
+	// one when it is next opened.
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(cfg.Path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(tmp)
+			return 0, err
+		}
+	}
+	if err := os.Rename(tmp, cfg.Path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return version, nil
+}
+
+// checkBackup returns the schema version of the backup at path, without
+// writing to it.
+func checkBackup(cfg config.DatabaseConfig, path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+
+	backupCfg := cfg
+	backupCfg.Path = path
+	backupCfg.JournalMode = ""
+	db, err := InitDB(backupCfg)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	var integrity string
+	if err := db.Raw("PRAGMA integrity_check").Scan(&integrity).Error; err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if integrity != "ok" {
+		return 0, fmt.Errorf("%w: integrity check failed: %s", ErrInvalidBackup, integrity)
+	}
+
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return 0, fmt.Errorf("%w: %s has no schema version", ErrInvalidBackup, path)
+	}
+	var version int
+	if err := db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error; err != nil {
+		return 0, err
+	}
+	if version == 0 {
+		return 0, fmt.Errorf("%w: %s has no schema version", ErrInvalidBackup, path)
+	}
+	if version > LatestVersion() {
+		return 0, fmt.Errorf("%w: schema version %d is newer than the latest known version %d", ErrInvalidBackup, version, LatestVersion())
+	}
+	return version, nil
+}
+
+// BackupFile describes a backup written by Backups.
+type BackupFile struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Backups writes backups of a database to a directory, named after the time
+// they were taken.
+type Backups struct {
+	db  *gorm.DB
+	dir string
+}
+
+func NewBackups(db *gorm.DB, dir string) *Backups {
+	return &Backups{db: db, dir: dir}
+}
+
+// Create writes a backup of the database to the backup directory.
+func (b *Backups) Create() (BackupFile, error) {
+	if err := os.MkdirAll(b.dir, 0o755); err != nil {
+		return BackupFile{}, err
+	}
+
+	now := time.Now().UTC()
+	path := filepath.Join(b.dir, "card-api-"+now.Format("20060102T150405.000000000Z")+".db")
+	if err := Backup(b.db, path); err != nil {
+		return BackupFile{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	return BackupFile{Path: path, Size: info.Size(), CreatedAt: now}, nil
+}
+
+// tempPath returns a file name next to path for writing what is then renamed
+// to path.
+func tempPath(path string) string {
+	return fmt.Sprintf("%s.tmp-%d", path, time.Now().UnixNano())
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,9 @@
+//go:build cgo
+
+package database
+
+import "gorm.io/driver/sqlite"
+
+func init() {
+	dialectors[DriverSQLite] = sqlite.Open
+}
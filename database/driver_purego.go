@@ -0,0 +1,7 @@
+package database
+
+import "github.com/glebarez/sqlite"
+
+func init() {
+	dialectors[DriverSQLitePureGo] = sqlite.Open
+}
@@ -1,15 +1,88 @@
 package database
 
 import (
-	"gorm.io/driver/sqlite"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/lando-ke/card-api/config"
 	"gorm.io/gorm"
 )
 
-func InitDB() (*gorm.DB, error) {
-	db, err := gorm.Open(sqlite.Open("card-api.db"), &gorm.Config{})
+const (
+	DriverSQLite       = "sqlite"
+	DriverSQLitePureGo = "sqlite-purego"
+)
+
+// dialectors holds the drivers compiled into the binary; the CGO driver is
+// left out of builds without CGO.
+var dialectors = map[string]func(dsn string) gorm.Dialector{}
+
+func InitDB(cfg config.DatabaseConfig) (*gorm.DB, error) {
+	driver := cfg.Driver
+	if driver == "" {
+		driver = DriverSQLitePureGo
+		if _, ok := dialectors[DriverSQLite]; ok {
+			driver = DriverSQLite
+		}
+	}
+
+	open, ok := dialectors[driver]
+	if !ok {
+		return nil, fmt.Errorf("database driver %q is not available in this build", driver)
+	}
+
+	db, err := gorm.Open(open(DSN(driver, cfg)), &gorm.Config{})
 	if err != nil {
 		return nil, err
 	}
 
+	if isMemory(cfg.Path) {
+		// Every connection to ":memory:" opens a database of its own, so the
+		// pool is kept to the one connection that holds the tables.
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+
 	return db, nil
 }
+
+// DSN builds the data source name for driver. The two drivers take the same
+// settings through different query parameters.
+func DSN(driver string, cfg config.DatabaseConfig) string {
+	params := url.Values{}
+	params.Set("_txlock", "immediate")
+
+	busyTimeout := fmt.Sprint(cfg.BusyTimeout.Milliseconds())
+	journalMode := cfg.JournalMode
+	if isMemory(cfg.Path) {
+		journalMode = ""
+	}
+
+	switch driver {
+	case DriverSQLitePureGo:
+		params.Add("_pragma", "busy_timeout("+busyTimeout+")")
+		if journalMode != "" {
+			params.Add("_pragma", "journal_mode("+journalMode+")")
+		}
+	default:
+		params.Set("_busy_timeout", busyTimeout)
+		if journalMode != "" {
+			params.Set("_journal_mode", journalMode)
+		}
+	}
+
+	separator := "?"
+	if strings.Contains(cfg.Path, "?") {
+		separator = "&"
+	}
+
+	return cfg.Path + separator + params.Encode()
+}
+
+func isMemory(path string) bool {
+	return path == ":memory:" || strings.HasPrefix(path, "file::memory:")
+}
@@ -1,15 +1,393 @@
 package database
 
 import (
-	"github.com/lando-ke/card-api/models"
+	"fmt"
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// Migration is one numbered step of the database schema. Up moves the schema
+// from Version-1 to Version and Down undoes it. Migrations describe the
+// schema as it was at their version, never the current models, so that they
+// keep producing the same result as the models change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration is a row of the schema version table, one per applied
+// migration.
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// MigrationStatus tells whether a migration has been applied.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrations lists every migration in version order. Databases created
+// before the schema was versioned already hold some of these tables, so the
+// first migrations only create what is missing.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_decks_and_cards",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"CREATE TABLE IF NOT EXISTS `decks` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`deck_id` uuid,`shuffled` numeric,`remaining` integer,PRIMARY KEY (`id`,`deck_id`))",
+				"CREATE INDEX IF NOT EXISTS `idx_decks_deleted_at` ON `decks`(`deleted_at`)",
+				"CREATE TABLE IF NOT EXISTS `cards` (`id` integer,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`value` varchar(255),`suit` varchar(255),`code` varchar(255),`deck_id` integer,PRIMARY KEY (`id`),CONSTRAINT `fk_decks_cards` FOREIGN KEY (`deck_id`) REFERENCES `decks`(`id`))",
+				"CREATE INDEX IF NOT EXISTS `idx_cards_deck_id` ON `cards`(`deck_id`)",
+				"CREATE INDEX IF NOT EXISTS `idx_cards_deleted_at` ON `cards`(`deleted_at`)",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"DROP TABLE IF EXISTS `cards`",
+				"DROP TABLE IF EXISTS `decks`",
+			)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_deck_operations",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"CREATE TABLE IF NOT EXISTS `deck_operations` (`id` integer,`deck_id` text,`method` varchar(32),`count` integer,`seed` integer,`seed_hash` varchar(64),`fingerprint` varchar(64),`created_at` datetime,PRIMARY KEY (`id`))",
+				"CREATE INDEX IF NOT EXISTS `idx_deck_operations_deck_id` ON `deck_operations`(`deck_id`)",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx, "DROP TABLE IF EXISTS `deck_operations`")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "add_card_positions",
+		Up: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn("cards", "position") {
+				if err := tx.Exec("ALTER TABLE `cards` ADD `position` integer NOT NULL DEFAULT 0").Error; err != nil {
+					return err
+				}
+			}
+			return execAll(tx,
+				"CREATE INDEX IF NOT EXISTS `idx_cards_position` ON `cards`(`position`)",
+				backfillCardPositions,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"DROP INDEX IF EXISTS `idx_cards_position`",
+				"ALTER TABLE `cards` DROP COLUMN `position`",
+			)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add_deck_expiry",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"ALTER TABLE `decks` ADD `expires_at` datetime",
+				"CREATE INDEX IF NOT EXISTS `idx_decks_expires_at` ON `decks`(`expires_at`)",
+				"CREATE INDEX IF NOT EXISTS `idx_decks_updated_at` ON `decks`(`updated_at`)",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"DROP INDEX IF EXISTS `idx_decks_updated_at`",
+				"DROP INDEX IF EXISTS `idx_decks_expires_at`",
+				"ALTER TABLE `decks` DROP COLUMN `expires_at`",
+			)
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_deck_event_log",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"ALTER TABLE `deck_operations` ADD `seq` integer NOT NULL DEFAULT 0",
+				"ALTER TABLE `deck_operations` ADD `cards` text",
+				backfillOperationSeq,
+				"CREATE UNIQUE INDEX IF NOT EXISTS `idx_deck_operations_deck_seq` ON `deck_operations`(`deck_id`,`seq`)",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"DROP INDEX IF EXISTS `idx_deck_operations_deck_seq`",
+				"ALTER TABLE `deck_operations` DROP COLUMN `cards`",
+				"ALTER TABLE `deck_operations` DROP COLUMN `seq`",
+			)
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_deck_versions",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"ALTER TABLE `decks` ADD `version` integer NOT NULL DEFAULT 1",
+				backfillDeckVersions,
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx, "ALTER TABLE `decks` DROP COLUMN `version`")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_compact_card_order",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx, "ALTER TABLE `decks` ADD `card_order` blob")
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx, "ALTER TABLE `decks` DROP COLUMN `card_order`")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create_deck_snapshots",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"CREATE TABLE IF NOT EXISTS `deck_snapshots` (`id` integer,`deck_id` text,`name` varchar(64),`version` integer,`shuffled` numeric,`remaining` integer,`cards` text,`drawn` text,`fingerprint` varchar(64),`created_at` datetime,PRIMARY KEY (`id`))",
+				"CREATE UNIQUE INDEX IF NOT EXISTS `idx_deck_snapshots_deck_name` ON `deck_snapshots`(`deck_id`,`name`)",
+				"ALTER TABLE `deck_operations` ADD `shuffled` numeric",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"ALTER TABLE `deck_operations` DROP COLUMN `shuffled`",
+				"DROP TABLE IF EXISTS `deck_snapshots`",
+			)
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_sealed_seeds",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx, "ALTER TABLE `deck_operations` ADD `sealed_seed` text")
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx, "ALTER TABLE `deck_operations` DROP COLUMN `sealed_seed`")
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add_deck_reveal_policies",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"ALTER TABLE `decks` ADD `reveal_policy` varchar(32) NOT NULL DEFAULT 'full'",
+				"ALTER TABLE `decks` ADD `owner_token_hash` varchar(64)",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"ALTER TABLE `decks` DROP COLUMN `owner_token_hash`",
+				"ALTER TABLE `decks` DROP COLUMN `reveal_policy`",
+			)
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add_card_states",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"ALTER TABLE `cards` ADD `state` varchar(16) NOT NULL DEFAULT 'in_deck'",
+				"ALTER TABLE `cards` ADD `holder` varchar(64)",
+				"ALTER TABLE `cards` ADD `state_changed_at` datetime",
+				drawnCardStates,
+				"CREATE INDEX IF NOT EXISTS `idx_cards_state` ON `cards`(`state`)",
+				"ALTER TABLE `deck_operations` ADD `holder` varchar(64)",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx,
+				"ALTER TABLE `deck_operations` DROP COLUMN `holder`",
+				softDeletedCards,
+				"DROP INDEX IF EXISTS `idx_cards_state`",
+				"ALTER TABLE `cards` DROP COLUMN `state_changed_at`",
+				"ALTER TABLE `cards` DROP COLUMN `holder`",
+				"ALTER TABLE `cards` DROP COLUMN `state`",
+			)
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_shuffle_seeds",
+		Up: func(tx *gorm.DB) error {
+			return execAll(tx, "ALTER TABLE `deck_operations` ADD `shuffle_seed` varchar(64)")
+		},
+		Down: func(tx *gorm.DB) error {
+			return execAll(tx, "ALTER TABLE `deck_operations` DROP COLUMN `shuffle_seed`")
+		},
+	},
+}
+
+// backfillCardPositions numbers the cards of decks created before cards had
+// a position, keeping the insertion order that used to define draw order.
+const backfillCardPositions = `
+	UPDATE cards SET position = (
+		SELECT COUNT(*) FROM cards AS earlier
+		WHERE earlier.deck_id = cards.deck_id AND earlier.id < cards.id
+	)
+	WHERE deck_id IN (
+		SELECT deck_id FROM cards
+		GROUP BY deck_id
+		HAVING COUNT(*) > 1 AND MAX(position) = 0
+	)`
+
+// drawnCardStates turns the cards that used to be marked as drawn by a soft
+// delete into drawn cards, drawn when they were deleted.
+const drawnCardStates = `
+	UPDATE cards SET state = 'drawn', state_changed_at = deleted_at, deleted_at = NULL
+	WHERE deleted_at IS NOT NULL`
+
+// softDeletedCards marks every card that left its deck as drawn the way it
+// was done before cards had a state.
+const softDeletedCards = `
+	UPDATE cards SET deleted_at = COALESCE(state_changed_at, CURRENT_TIMESTAMP)
+	WHERE state <> 'in_deck' AND deleted_at IS NULL`
+
+// backfillOperationSeq numbers the operations recorded before they formed an
+// event log, in the order they were recorded. Their cards stay empty, so
+// decks from that time cannot be rebuilt from their events.
+const backfillOperationSeq = `
+	UPDATE deck_operations SET seq = (
+		SELECT COUNT(*) FROM deck_operations AS earlier
+		WHERE earlier.deck_id = deck_operations.deck_id AND earlier.id <= deck_operations.id
+	)`
+
+// backfillDeckVersions gives existing decks the version their events add up
+// to, as every event moves a deck on by one version.
+const backfillDeckVersions = `
+	UPDATE decks SET version = MAX(1, (
+		SELECT COUNT(*) FROM deck_operations
+		WHERE deck_operations.deck_id = decks.deck_id
+	))`
+
+// RunMigrations applies every pending migration.
 func RunMigrations(db *gorm.DB) error {
-	err := db.AutoMigrate(&models.Deck{}, &models.Card{})
+	_, err := MigrateUp(db)
+	return err
+}
+
+// LatestVersion is the schema version this build expects.
+func LatestVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version of the last applied migration, or 0 for
+// a database that has never been migrated.
+func SchemaVersion(db *gorm.DB) (int, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// MigrateUp applies the pending migrations in order and returns them. Each
+// migration runs in its own transaction together with its version row.
+func MigrateUp(db *gorm.DB) ([]Migration, error) {
+	version, err := SchemaVersion(db)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	if version > LatestVersion() {
+		return nil, fmt.Errorf("database schema version %d is newer than the latest known version %d", version, LatestVersion())
+	}
+
+	applied := []Migration{}
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
 
+		applied = append(applied, m)
+	}
+
+	return applied, nil
+}
+
+// MigrateDown rolls back the last steps applied migrations, newest first,
+// and returns them.
+func MigrateDown(db *gorm.DB, steps int) ([]Migration, error) {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := []Migration{}
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m := migrations[i]
+		if m.Version > version {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+
+		reverted = append(reverted, m)
+	}
+
+	return reverted, nil
+}
+
+// Status lists every known migration and whether it has been applied.
+func Status(db *gorm.DB) ([]MigrationStatus, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+
+	var rows []SchemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	appliedAt := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		appliedAt[row.Version] = row.AppliedAt
+	}
+
+	status := []MigrationStatus{}
+	for _, m := range migrations {
+		at, ok := appliedAt[m.Version]
+		status = append(status, MigrationStatus{Migration: m, Applied: ok, AppliedAt: at})
+	}
+
+	return status, nil
+}
+
+func execAll(tx *gorm.DB, statements ...string) error {
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,16 @@
+// Package docs holds the OpenAPI specification of the API and an HTML page
+// that renders it, both embedded in the binary.
+package docs
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI 3 specification of every endpoint.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// Page is a self-contained HTML page that renders OpenAPI, which it loads
+// from /openapi.json.
+//
+//go:embed index.html
+var Page []byte
@@ -0,0 +1,210 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// prefix starts every value written by Encrypt. It is followed by the ID of
+// the key, a colon and the base64 encoded nonce and ciphertext.
+const prefix = "enc:v1:"
+
+// blockSize is the size plaintexts are padded to a multiple of, so that the
+// length of a ciphertext does not tell a "10" from a "2".
+const blockSize = 16
+
+var (
+	// ErrUnknownKey is returned for data encrypted with a key that is not in
+	// the keyring.
+	ErrUnknownKey = errors.New("data is encrypted with a key that is not configured")
+	// ErrInvalidKeys is returned for a key specification that cannot be
+	// parsed.
+	ErrInvalidKeys = errors.New("invalid encryption keys")
+
+	keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+)
+
+// Keyring encrypts data with AES-256-GCM under its primary key, and
+// decrypts data written under any of its keys. Rotating keys means adding a
+// new primary key while keeping the old ones until every value has been
+// encrypted again.
+//
+// A nil *Keyring leaves data unencrypted, so that callers need not tell
+// whether encryption is turned on.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring builds a keyring from 32 byte keys by ID. primary names the key
+// new data is encrypted with.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("%w: primary key %q is missing", ErrInvalidKeys, primary)
+	}
+
+	k := &Keyring{primary: primary, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("%w: key ID %q must be 1 to 32 letters, digits, '_' or '-'", ErrInvalidKeys, id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("%w: key %q must be 32 bytes long, got %d", ErrInvalidKeys, id, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+	}
+	return k, nil
+}
+
+// ParseKeys builds a keyring from a comma separated list of id:key pairs,
+// where key is 32 bytes in standard base64. The first key is the primary
+// one. An empty spec returns a nil keyring, which turns encryption off.
+func ParseKeys(spec string) (*Keyring, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	primary := ""
+	keys := map[string][]byte{}
+	for i, pair := range strings.Split(spec, ",") {
+		// A misplaced key may end up anywhere in a pair, so errors name the
+		// entry by its index and never quote it.
+		id, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: entry %d is not an id:key pair", ErrInvalidKeys, i+1)
+		}
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("%w: the ID of entry %d must be 1 to 32 letters, digits, '_' or '-'", ErrInvalidKeys, i+1)
+		}
+		if _, ok := keys[id]; ok {
+			return nil, fmt.Errorf("%w: the ID of entry %d is listed twice", ErrInvalidKeys, i+1)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: the key of entry %d is not valid base64", ErrInvalidKeys, i+1)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("%w: the key of entry %d must be 32 bytes long, got %d", ErrInvalidKeys, i+1, len(key))
+		}
+		if primary == "" {
+			primary = id
+		}
+		keys[id] = key
+	}
+
+	return NewKeyring(primary, keys)
+}
+
+// PrimaryKeyID returns the ID of the key new data is encrypted with.
+func (k *Keyring) PrimaryKeyID() string {
+	if k == nil {
+		return ""
+	}
+	return k.primary
+}
+
+// Encrypt encrypts plaintext under the primary key. Empty data stays empty,
+// and a nil keyring returns plaintext as it is.
+func (k *Keyring) Encrypt(plaintext []byte) ([]byte, error) {
+	if k == nil || len(plaintext) == 0 {
+		return plaintext, nil
+	}
+
+	aead := k.keys[k.primary]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, pad(plaintext), []byte(k.primary))
+
+	return []byte(prefix + k.primary + ":" + base64.RawStdEncoding.EncodeToString(sealed)), nil
+}
+
+// Decrypt returns the plaintext of data written by Encrypt under any key of
+// the keyring. Data that is not encrypted, such as data written before
+// encryption was turned on, is returned as it is.
+func (k *Keyring) Decrypt(data []byte) ([]byte, error) {
+	id, ok := KeyID(data)
+	if !ok {
+		return data, nil
+	}
+	if k == nil || k.keys[id] == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+
+	aead := k.keys[id]
+	sealed, err := base64.RawStdEncoding.DecodeString(string(data[len(prefix)+len(id)+1:]))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is malformed")
+	}
+	padded, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt data with key %q: %w", id, err)
+	}
+	return unpad(padded)
+}
+
+// EncryptString is Encrypt for strings.
+func (k *Keyring) EncryptString(plaintext string) (string, error) {
+	data, err := k.Encrypt([]byte(plaintext))
+	return string(data), err
+}
+
+// DecryptString is Decrypt for strings.
+func (k *Keyring) DecryptString(data string) (string, error) {
+	plaintext, err := k.Decrypt([]byte(data))
+	return string(plaintext), err
+}
+
+// Current reports whether data needs no re-encryption: it is empty, or
+// encrypted under the primary key.
+func (k *Keyring) Current(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	id, ok := KeyID(data)
+	return ok && id == k.PrimaryKeyID()
+}
+
+// KeyID returns the ID of the key data is encrypted with, and false when
+// data is not encrypted.
+func KeyID(data []byte) (string, bool) {
+	if !bytes.HasPrefix(data, []byte(prefix)) {
+		return "", false
+	}
+	id, _, ok := strings.Cut(string(data[len(prefix):]), ":")
+	return id, ok
+}
+
+// pad appends a 0x80 byte and as many zero bytes as it takes to fill the
+// last block.
+func pad(data []byte) []byte {
+	n := blockSize - len(data)%blockSize
+	padded := make([]byte, len(data)+n)
+	copy(padded, data)
+	padded[len(data)] = 0x80
+	return padded
+}
+
+func unpad(data []byte) ([]byte, error) {
+	i := bytes.LastIndexByte(data, 0x80)
+	if i < 0 || len(bytes.Trim(data[i+1:], "\x00")) > 0 {
+		return nil, errors.New("encrypted data has invalid padding")
+	}
+	return data[:i], nil
+}
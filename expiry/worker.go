@@ -0,0 +1,84 @@
+package expiry
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lando-ke/card-api/store"
+)
+
+// Worker periodically deletes expired decks in batches, so that abandoned
+// decks do not pile up in the store.
+type Worker struct {
+	store     store.DeckStore
+	interval  time.Duration
+	idleTTL   time.Duration
+	batchSize int
+
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+func NewWorker(deckStore store.DeckStore, interval, idleTTL time.Duration, batchSize int) *Worker {
+	return &Worker{
+		store:     deckStore,
+		interval:  interval,
+		idleTTL:   idleTTL,
+		batchSize: batchSize,
+	}
+}
+
+// Start runs the worker in a new goroutine until Stop is called. A worker
+// without an interval does not run.
+func (w *Worker) Start() {
+	if w.interval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	w.done.Add(1)
+	go func() {
+		defer w.done.Done()
+
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := w.Purge(ctx); err != nil {
+					log.Printf("deleting expired decks: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the worker and waits for the batch in progress to finish.
+func (w *Worker) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	w.done.Wait()
+}
+
+// Purge deletes expired decks batch by batch until none are left or ctx is
+// done, and returns how many it deleted.
+func (w *Worker) Purge(ctx context.Context) (int, error) {
+	total := 0
+	for ctx.Err() == nil {
+		deleted, err := w.store.DeleteExpired(time.Now(), w.idleTTL, w.batchSize)
+		total += deleted
+		if err != nil || deleted < w.batchSize {
+			return total, err
+		}
+	}
+	return total, nil
+}
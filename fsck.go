@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/lando-ke/card-api/config"
+	"github.com/lando-ke/card-api/database"
+	"github.com/lando-ke/card-api/fsck"
+)
+
+func checkDecks(cfg config.Config, args []string) error {
+	repair := false
+	for _, arg := range args {
+		if arg != "--repair" {
+			return fmt.Errorf("unknown fsck argument %q", arg)
+		}
+		repair = true
+	}
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+
+	checker := fsck.NewChecker(db, fsck.WithKeyring(cfg.Encryption))
+	check := checker.Check
+	if repair {
+		check = checker.Repair
+	}
+	report, err := check()
+	if err != nil {
+		return err
+	}
+
+	if len(report.Problems) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DECK\tPROBLEM\tDETAIL\tREPAIRED")
+		for _, p := range report.Problems {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", p.DeckID, p.Kind, p.Detail, p.Repaired)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("checked %d decks, found %d problems\n", report.DecksChecked, len(report.Problems))
+	if n := report.Unrepaired(); n > 0 {
+		return fmt.Errorf("%d problems left, run `card-api fsck --repair` to repair them", n)
+	}
+	return nil
+}
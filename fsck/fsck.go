@@ -0,0 +1,279 @@
+package fsck
+
+import (
+	"fmt"
+
+	"github.com/lando-ke/card-api/encryption"
+	"github.com/lando-ke/card-api/models"
+	"gorm.io/gorm"
+)
+
+// Kinds of problems the checker finds.
+const (
+	// RemainingMismatch is a deck whose remaining count differs from the
+	// number of cards it holds.
+	RemainingMismatch = "remaining_mismatch"
+	// OrphanedCards are cards of a deck that does not exist.
+	OrphanedCards = "orphaned_cards"
+	// DuplicatePositions are cards of a deck that share a position, which
+	// leaves their draw order undefined.
+	DuplicatePositions = "duplicate_positions"
+	// CorruptCardOrder is a compact deck whose card order cannot be decrypted
+	// or decoded. It cannot be repaired.
+	CorruptCardOrder = "corrupt_card_order"
+)
+
+// Problem is an inconsistency found in the deck database.
+type Problem struct {
+	Kind     string `json:"kind"`
+	DeckID   string `json:"deck_id"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+// Report is the outcome of a check.
+type Report struct {
+	DecksChecked int       `json:"decks_checked"`
+	Problems     []Problem `json:"problems"`
+}
+
+// Unrepaired returns the number of problems that are still there.
+func (r Report) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// Checker finds, and optionally repairs, inconsistencies between decks and
+// their cards.
+type Checker struct {
+	db   *gorm.DB
+	keys *encryption.Keyring
+}
+
+// Option configures a Checker.
+type Option func(*Checker)
+
+// WithKeyring decrypts the card orders of decks written by a deck store
+// that encrypts them.
+func WithKeyring(keys *encryption.Keyring) Option {
+	return func(c *Checker) {
+		c.keys = keys
+	}
+}
+
+func NewChecker(db *gorm.DB, opts ...Option) *Checker {
+	c := &Checker{db: db}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Check looks for problems without changing anything.
+func (c *Checker) Check() (Report, error) {
+	return c.run(c.db, false)
+}
+
+// Repair looks for problems and repairs those it can, in one transaction so
+// that it sees and fixes a consistent state while decks are in use.
+func (c *Checker) Repair() (Report, error) {
+	var report Report
+	err := c.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		report, err = c.run(tx, true)
+		return err
+	})
+	return report, err
+}
+
+func (c *Checker) run(db *gorm.DB, repair bool) (Report, error) {
+	report := Report{Problems: []Problem{}}
+
+	var decks int64
+	if err := db.Unscoped().Model(&models.Deck{}).Count(&decks).Error; err != nil {
+		return Report{}, err
+	}
+	report.DecksChecked = int(decks)
+
+	// Orphans go first, so that later checks only see cards of real decks.
+	for _, check := range []func(*gorm.DB, bool) ([]Problem, error){
+		checkOrphanedCards,
+		checkDuplicatePositions,
+		checkRemaining,
+		c.checkCompactRemaining,
+	} {
+		problems, err := check(db, repair)
+		if err != nil {
+			return Report{}, err
+		}
+		report.Problems = append(report.Problems, problems...)
+	}
+
+	return report, nil
+}
+
+func checkOrphanedCards(db *gorm.DB, repair bool) ([]Problem, error) {
+	var rows []struct {
+		DeckID string
+		Cards  int
+	}
+	err := db.Raw(`
+		SELECT deck_id, COUNT(*) AS cards FROM cards
+		WHERE deck_id NOT IN (SELECT deck_id FROM decks)
+		GROUP BY deck_id`).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []Problem{}
+	for _, row := range rows {
+		problem := Problem{
+			Kind:   OrphanedCards,
+			DeckID: row.DeckID,
+			Detail: fmt.Sprintf("%d cards belong to no deck", row.Cards),
+		}
+		if repair {
+			if err := db.Unscoped().Where("deck_id = ?", row.DeckID).Delete(&models.Card{}).Error; err != nil {
+				return nil, err
+			}
+			problem.Repaired = true
+		}
+		problems = append(problems, problem)
+	}
+
+	return problems, nil
+}
+
+func checkDuplicatePositions(db *gorm.DB, repair bool) ([]Problem, error) {
+	var rows []struct {
+		DeckID string
+		Cards  int
+	}
+	err := db.Raw(`
+		SELECT deck_id, SUM(cards) AS cards FROM (
+			SELECT deck_id, COUNT(*) AS cards FROM cards
+			WHERE state = 'in_deck' AND deleted_at IS NULL
+			GROUP BY deck_id, position
+			HAVING COUNT(*) > 1
+		) GROUP BY deck_id`).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []Problem{}
+	for _, row := range rows {
+		problem := Problem{
+			Kind:   DuplicatePositions,
+			DeckID: row.DeckID,
+			Detail: fmt.Sprintf("%d cards share a position with another card", row.Cards),
+		}
+		if repair {
+			if err := renumberCards(db, row.DeckID); err != nil {
+				return nil, err
+			}
+			problem.Repaired = true
+		}
+		problems = append(problems, problem)
+	}
+
+	return problems, nil
+}
+
+// renumberCards gives the remaining cards of a deck distinct positions,
+// keeping their current order and breaking ties by insertion order.
+func renumberCards(db *gorm.DB, deckID string) error {
+	var cards []models.Card
+	if err := db.Where("deck_id = ? AND state = ?", deckID, models.CardInDeck).Order("position ASC, id ASC").Find(&cards).Error; err != nil {
+		return err
+	}
+
+	for i, card := range cards {
+		if card.Position == i {
+			continue
+		}
+		if err := db.Model(&models.Card{}).Where("id = ?", card.ID).UpdateColumn("position", i).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkRemaining(db *gorm.DB, repair bool) ([]Problem, error) {
+	var rows []struct {
+		DeckID    string
+		Remaining int
+		Cards     int
+	}
+	err := db.Raw(`
+		SELECT decks.deck_id, decks.remaining, COUNT(cards.id) AS cards FROM decks
+		LEFT JOIN cards ON cards.deck_id = decks.deck_id AND cards.state = 'in_deck' AND cards.deleted_at IS NULL
+		WHERE decks.card_order IS NULL
+		GROUP BY decks.deck_id, decks.remaining
+		HAVING decks.remaining <> COUNT(cards.id)`).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []Problem{}
+	for _, row := range rows {
+		problem, err := remainingProblem(db, repair, row.DeckID, row.Remaining, row.Cards)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, problem)
+	}
+
+	return problems, nil
+}
+
+func (c *Checker) checkCompactRemaining(db *gorm.DB, repair bool) ([]Problem, error) {
+	var decks []models.Deck
+	if err := db.Unscoped().Select("deck_id", "remaining", "card_order").Where("card_order IS NOT NULL").Find(&decks).Error; err != nil {
+		return nil, err
+	}
+
+	problems := []Problem{}
+	for _, deck := range decks {
+		order, err := c.keys.Decrypt(deck.CardOrder)
+		if err != nil {
+			problems = append(problems, Problem{Kind: CorruptCardOrder, DeckID: deck.DeckID, Detail: err.Error()})
+			continue
+		}
+		remaining, _, err := models.DecodeCardOrder(order)
+		if err != nil {
+			problems = append(problems, Problem{Kind: CorruptCardOrder, DeckID: deck.DeckID, Detail: err.Error()})
+			continue
+		}
+		if deck.Remaining == len(remaining) {
+			continue
+		}
+
+		problem, err := remainingProblem(db, repair, deck.DeckID, deck.Remaining, len(remaining))
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, problem)
+	}
+
+	return problems, nil
+}
+
+func remainingProblem(db *gorm.DB, repair bool, deckID string, remaining, cards int) (Problem, error) {
+	problem := Problem{
+		Kind:   RemainingMismatch,
+		DeckID: deckID,
+		Detail: fmt.Sprintf("remaining is %d but the deck holds %d cards", remaining, cards),
+	}
+	if repair {
+		if err := db.Unscoped().Model(&models.Deck{}).Where("deck_id = ?", deckID).UpdateColumn("remaining", cards).Error; err != nil {
+			return Problem{}, err
+		}
+		problem.Repaired = true
+	}
+	return problem, nil
+}
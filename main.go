@@ -1,23 +1,145 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lando-ke/card-api/config"
+	"github.com/lando-ke/card-api/controllers"
 	"github.com/lando-ke/card-api/database"
+	"github.com/lando-ke/card-api/expiry"
+	"github.com/lando-ke/card-api/fsck"
 	"github.com/lando-ke/card-api/routes"
+	"github.com/lando-ke/card-api/store"
 	"github.com/gin-gonic/gin"
 )
 
+const usage = `usage: card-api [command]
+
+commands:
+  serve                  run the API server (default)
+  migrate up             apply every pending schema migration
+  migrate down [steps]   roll back the last steps migrations (default 1)
+  migrate status         list the schema migrations and whether they are applied
+  fsck [--repair]        check decks for inconsistencies and optionally repair them
+  rekey                  encrypt every deck with the first of the encryption keys
+  backup <path>          write a consistent copy of the database to path, also while serving
+  restore <path>         replace the database with the backup at path; stop the server first`
+
 func main() {
-	dbInstance, err := database.InitDB()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	command := "serve"
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	switch command {
+	case "serve":
+		err = serve(cfg)
+	case "migrate":
+		err = migrate(cfg, os.Args[2:])
+	case "fsck":
+		err = checkDecks(cfg, os.Args[2:])
+	case "rekey":
+		err = rekey(cfg, os.Args[2:])
+	case "backup":
+		err = backup(cfg, os.Args[2:])
+	case "restore":
+		err = restore(cfg, os.Args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serve(cfg config.Config) error {
+	dbInstance, err := database.InitDB(cfg.Database)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	err = database.RunMigrations(dbInstance)
+	version, err := database.SchemaVersion(dbInstance)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	if version < database.LatestVersion() {
+		if !cfg.AutoMigrate {
+			return fmt.Errorf("database schema is at version %d but %d is required, run `card-api migrate up`", version, database.LatestVersion())
+		}
+
+		applied, err := database.MigrateUp(dbInstance)
+		if err != nil {
+			return err
+		}
+		for _, m := range applied {
+			log.Printf("applied migration %d %s", m.Version, m.Name)
+		}
+	}
+
+	storeOpts := []store.GormOption{store.WithEncryption(cfg.Encryption)}
+	if cfg.Storage == config.StorageCompact {
+		storeOpts = append(storeOpts, store.WithCompactStorage())
+	}
+	var deckStore store.DeckStore = store.NewGormDeckStore(dbInstance, storeOpts...)
+	var actors *store.ActorDeckStore
+	if cfg.DeckActors {
+		actors = store.NewActorDeckStore(deckStore, cfg.ActorMailboxSize, cfg.ActorIdleTimeout)
+		// Deferred before the worker is, so that it runs after the worker
+		// has stopped and writes the changes the actors still hold.
+		defer actors.Close()
+		deckStore = actors
+	}
+	var cache *store.CachedDeckStore
+	if cfg.DeckCacheSize > 0 {
+		cache = store.NewCachedDeckStore(deckStore, cfg.DeckCacheSize)
+		deckStore = cache
+	}
+
+	worker := expiry.NewWorker(deckStore, cfg.CleanupInterval, cfg.DeckIdleTTL, cfg.CleanupBatchSize)
+	worker.Start()
+	defer worker.Stop()
 
 	r := gin.Default()
-	routes.RegisterDeckRoutes(r, dbInstance)
-	r.Run(":8080")
+	routes.RegisterDeckRoutes(r, deckStore, controllers.WithIdleTTL(cfg.DeckIdleTTL), controllers.WithUndoDepth(cfg.UndoDepth))
+	routes.RegisterDocsRoutes(r)
+	routes.RegisterAdminRoutes(r, dbInstance, cfg.AdminToken, cache, actors, cfg.BackupDir, fsck.WithKeyring(cfg.Encryption))
+
+	return run(&http.Server{Addr: cfg.Addr, Handler: r})
+}
+
+// run serves HTTP until the process is interrupted, then lets the requests
+// in flight finish.
+func run(srv *http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
 }
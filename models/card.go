@@ -2,15 +2,38 @@ package models
 
 import (
 	"encoding/json"
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// States of a card. A card in the deck can be drawn; every other state
+// means the card has left the deck.
+const (
+	CardInDeck  = "in_deck"
+	CardDrawn   = "drawn"
+	CardInPile  = "in_pile"
+	CardBurned  = "burned"
+	CardRemoved = "removed"
+)
+
 type Card struct {
 	gorm.Model
 	Value  string `json:"value" gorm:"type:varchar(255)"`
 	Suit   string `json:"suit" gorm:"type:varchar(255)"`
 	Code   string `json:"code" gorm:"type:varchar(255)"`
 	DeckID string `json:"-" gorm:"index"`
+	// Position is the place of the card in its deck's draw order; the card
+	// with the lowest position is drawn first.
+	Position int `json:"-" gorm:"index;not null;default:0"`
+	// State is where the card is, one of the Card* states.
+	State string `json:"-" gorm:"type:varchar(16);index;not null;default:in_deck"`
+	// Holder is who holds a card that left the deck, such as the player who
+	// drew it. It is empty when nobody was named.
+	Holder string `json:"-" gorm:"type:varchar(64)"`
+	// StateChangedAt is when the card last changed state, nil while it has
+	// never left the deck.
+	StateChangedAt *time.Time `json:"-"`
 }
 
 func (card Card) MarshalJSON() ([]byte, error) {
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	CardValues = []string{"2", "3", "4", "5", "6", "7", "8", "9", "10", "JACK", "QUEEN", "KING", "ACE"}
+	CardSuits  = []string{"SPADES", "DIAMONDS", "CLUBS", "HEARTS"}
+)
+
+// encodedDrawn marks a drawn card in an encoded card order.
+const encodedDrawn = 0x80
+
+// cardTable lists every card an encoded card order can hold; a card is
+// encoded as its index. Full decks have always coded the tens with a single
+// "1", so those codes have entries of their own after the 52 cards.
+var cardTable, cardIndex = buildCardTable()
+
+func buildCardTable() ([]Card, map[string]byte) {
+	table := []Card{}
+	for _, suit := range CardSuits {
+		for _, value := range CardValues {
+			code := value[:1] + suit[:1]
+			if value == "10" {
+				code = value + suit[:1]
+			}
+			table = append(table, Card{Value: value, Suit: suit, Code: code})
+		}
+	}
+	for _, suit := range CardSuits {
+		table = append(table, Card{Value: "10", Suit: suit, Code: "1" + suit[:1]})
+	}
+
+	index := make(map[string]byte, len(table))
+	for i, card := range table {
+		index[card.Code] = byte(i)
+	}
+	return table, index
+}
+
+// EncodeCardOrder packs the cards of a deck into one byte per card: first
+// the remaining cards in draw order, then the drawn cards in the order they
+// were drawn, marked as drawn.
+func EncodeCardOrder(remaining, drawn []Card) ([]byte, error) {
+	data := make([]byte, 0, len(remaining)+len(drawn))
+	for _, card := range remaining {
+		i, ok := cardIndex[card.Code]
+		if !ok {
+			return nil, fmt.Errorf("card %s cannot be encoded", card.Code)
+		}
+		data = append(data, i)
+	}
+	for _, card := range drawn {
+		i, ok := cardIndex[card.Code]
+		if !ok {
+			return nil, fmt.Errorf("card %s cannot be encoded", card.Code)
+		}
+		data = append(data, i|encodedDrawn)
+	}
+	return data, nil
+}
+
+// DecodeCardOrder unpacks a card order written by EncodeCardOrder.
+func DecodeCardOrder(data []byte) (remaining, drawn []Card, err error) {
+	remaining = []Card{}
+	drawn = []Card{}
+	for _, b := range data {
+		i := int(b &^ encodedDrawn)
+		if i >= len(cardTable) {
+			return nil, nil, errors.New("invalid card in encoded card order")
+		}
+		card := cardTable[i]
+		if b&encodedDrawn != 0 {
+			card.State = CardDrawn
+			drawn = append(drawn, card)
+		} else {
+			if len(drawn) > 0 {
+				return nil, nil, errors.New("remaining card after a drawn card in encoded card order")
+			}
+			card.State = CardInDeck
+			remaining = append(remaining, card)
+		}
+	}
+	return remaining, drawn, nil
+}
@@ -14,6 +14,21 @@ type Deck struct {
 	Shuffled  bool   `json:"shuffled"`
 	Remaining int    `json:"remaining"`
 	Cards     []Card `json:"cards" gorm:"foreignKey:DeckID"`
+	// ExpiresAt is set for decks created with a TTL. Other decks expire once
+	// they have been idle for the server-wide idle TTL.
+	ExpiresAt *time.Time `json:"expires_at,omitempty" gorm:"index"`
+	// Version starts at 1 and is incremented by every change to the deck.
+	Version int `json:"version" gorm:"not null;default:1"`
+	// CardOrder holds the cards of decks stored in the compact layout, as
+	// written by EncodeCardOrder. It is nil for decks stored as card rows.
+	CardOrder []byte `json:"-"`
+	// RevealPolicy is how much of the remaining cards clients may see, one
+	// of RevealPolicies. Decks created before there were policies have none
+	// and are shown in full.
+	RevealPolicy string `json:"reveal_policy" gorm:"type:varchar(32);not null;default:full"`
+	// OwnerTokenHash is the hash of the token that shows the owner of a
+	// RevealOwnerOnly deck its cards.
+	OwnerTokenHash string `json:"-" gorm:"type:varchar(64)"`
 }
 
 func (deck Deck) MarshalJSON() ([]byte, error) {
@@ -34,8 +49,19 @@ func (deck Deck) MarshalJSON() ([]byte, error) {
 }
 
 
+// Expired reports whether the deck has expired at now. idleTTL applies to
+// decks without an expiry of their own; zero means they never expire.
+func (deck Deck) Expired(now time.Time, idleTTL time.Duration) bool {
+	if deck.ExpiresAt != nil {
+		return !now.Before(*deck.ExpiresAt)
+	}
+	return idleTTL > 0 && !now.Before(deck.UpdatedAt.Add(idleTTL))
+}
+
 func (deck *Deck) BeforeCreate(tx *gorm.DB) (err error) {
-	deck.DeckID = uuid.New().String()
+	if deck.DeckID == "" {
+		deck.DeckID = uuid.New().String()
+	}
 	deck.CreatedAt = time.Now()
 	deck.UpdatedAt = time.Now()
 	return
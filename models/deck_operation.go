@@ -0,0 +1,106 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MethodCreate  = "create"
+	MethodShuffle = "shuffle"
+	MethodDraw    = "draw"
+	MethodUndo    = "undo"
+	MethodRestore = "restore"
+)
+
+// DeckOperation is one event in a deck's append-only event log. Every change
+// made to a deck is recorded together with the fingerprint of the card order
+// it left behind, so the history can be checked against what players saw,
+// and with enough detail to rebuild the deck as it was after any event.
+type DeckOperation struct {
+	ID     uint   `json:"-" gorm:"primarykey"`
+	DeckID string `json:"-" gorm:"index;uniqueIndex:idx_deck_operations_deck_seq,priority:1"`
+	// Seq numbers the events of a deck from 1.
+	Seq    int    `json:"event_no" gorm:"not null;default:0;uniqueIndex:idx_deck_operations_deck_seq,priority:2"`
+	Method string `json:"method" gorm:"type:varchar(32)"`
+	Count  int    `json:"count"`
+	// Seed is the seed of a shuffle recorded before shuffles took 32 byte
+	// seeds, and ShuffleSeed the hex encoded seed of a later one.
+	Seed        *int64 `json:"-"`
+	ShuffleSeed string `json:"-" gorm:"type:varchar(64)"`
+	// SealedSeed holds the seed instead of Seed or ShuffleSeed when the deck
+	// store encrypts its data.
+	SealedSeed  string `json:"-"`
+	SeedHash    string `json:"seed_hash,omitempty" gorm:"type:varchar(64)"`
+	Fingerprint string `json:"fingerprint" gorm:"type:varchar(64)"`
+	// Shuffled is whether the deck counted as shuffled after the operation.
+	Shuffled bool `json:"shuffled"`
+	// Holder is who the cards a draw took off the deck went to.
+	Holder string `json:"holder,omitempty" gorm:"type:varchar(64)"`
+	// Cards holds the comma separated codes of the cards drawn by a draw,
+	// and the whole order of the remaining cards after any other event. The
+	// Count of an undo is the number of operations it undid.
+	Cards     string    `json:"-"`
+	CreatedAt time.Time `json:"timestamp"`
+}
+
+// NewDeckOperation builds an operation record for cards, the remaining cards
+// of the deck in draw order after the operation was applied. seed is the seed
+// of the shuffle, if any.
+func NewDeckOperation(method string, count int, seed []byte, cards []Card) DeckOperation {
+	op := DeckOperation{
+		Method:      method,
+		Count:       count,
+		Fingerprint: OrderFingerprint(cards),
+		Cards:       strings.Join(CardCodes(cards), ","),
+	}
+	if seed != nil {
+		op.ShuffleSeed = hex.EncodeToString(seed)
+		op.SeedHash = HashShuffleSeed(seed)
+	}
+	return op
+}
+
+// Seeded reports whether op records the seed of a shuffle.
+func (op DeckOperation) Seeded() bool {
+	return op.Seed != nil || op.ShuffleSeed != ""
+}
+
+// NewDrawOperation builds the operation record of a draw that took drawn off
+// the deck and left remaining.
+func NewDrawOperation(drawn []Card, remaining []Card) DeckOperation {
+	op := NewDeckOperation(MethodDraw, len(drawn), nil, remaining)
+	op.Cards = strings.Join(CardCodes(drawn), ",")
+	return op
+}
+
+// CardCodes returns the codes of cards in order.
+func CardCodes(cards []Card) []string {
+	codes := make([]string, len(cards))
+	for i, card := range cards {
+		codes[i] = card.Code
+	}
+	return codes
+}
+
+// OrderFingerprint returns a SHA-256 digest of the card codes in order.
+func OrderFingerprint(cards []Card) string {
+	sum := sha256.Sum256([]byte(strings.Join(CardCodes(cards), ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashShuffleSeed returns the SHA-256 digest of a shuffle seed. Only the hash
+// is published so that the seed cannot be used to predict the deck order.
+func HashShuffleSeed(seed []byte) string {
+	sum := sha256.Sum256(seed)
+	return hex.EncodeToString(sum[:])
+}
+
+// HashSeed is HashShuffleSeed for the 64 bit seeds of older shuffles.
+func HashSeed(seed int64) string {
+	sum := sha256.Sum256([]byte(strconv.FormatInt(seed, 10)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// DeckSnapshot is the state of a deck saved under a name, so that the deck
+// can be restored to it later.
+type DeckSnapshot struct {
+	ID     uint   `json:"-" gorm:"primarykey"`
+	DeckID string `json:"-" gorm:"uniqueIndex:idx_deck_snapshots_deck_name,priority:1"`
+	Name   string `json:"name" gorm:"type:varchar(64);uniqueIndex:idx_deck_snapshots_deck_name,priority:2"`
+	// Version is the version of the deck when it was saved.
+	Version   int  `json:"version"`
+	Shuffled  bool `json:"shuffled"`
+	Remaining int  `json:"remaining"`
+	// Cards holds the comma separated codes of the remaining cards in draw
+	// order, and Drawn those of the cards no longer in the deck.
+	Cards       string    `json:"-"`
+	Drawn       string    `json:"-"`
+	Fingerprint string    `json:"fingerprint" gorm:"type:varchar(64)"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Reveal policies say how much of the remaining cards of a deck its clients
+// may see. Cards that are drawn are always shown to whoever draws them.
+const (
+	// RevealFull shows the remaining cards in draw order.
+	RevealFull = "full"
+	// RevealCompositionOnly shows the remaining cards sorted, which tells
+	// what is left but not what comes next.
+	RevealCompositionOnly = "composition_only"
+	// RevealCountOnly shows how many cards remain but not which.
+	RevealCountOnly = "count_only"
+	// RevealOwnerOnly shows the remaining cards in draw order to the owner
+	// of the deck, and only their number to anyone else.
+	RevealOwnerOnly = "owner_only"
+)
+
+// RevealPolicies lists the valid reveal policies.
+var RevealPolicies = []string{RevealFull, RevealCompositionOnly, RevealCountOnly, RevealOwnerOnly}
+
+// ValidRevealPolicy reports whether policy is one of RevealPolicies.
+func ValidRevealPolicy(policy string) bool {
+	for _, p := range RevealPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// HashOwnerToken returns the SHA-256 digest of an owner token. Only the hash
+// is stored, so that the database does not hand out the token.
+func HashOwnerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lando-ke/card-api/config"
+	"github.com/lando-ke/card-api/database"
+	"github.com/lando-ke/card-api/store"
+)
+
+func rekey(cfg config.Config, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unknown rekey argument %q", args[0])
+	}
+	if cfg.Encryption == nil {
+		return fmt.Errorf("rekey needs the keys in CARD_API_ENCRYPTION_KEYS")
+	}
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		return err
+	}
+	version, err := database.SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version < database.LatestVersion() {
+		return fmt.Errorf("database schema is at version %d but %d is required, run `card-api migrate up`", version, database.LatestVersion())
+	}
+
+	deckStore := store.NewGormDeckStore(db, store.WithEncryption(cfg.Encryption))
+	rekeyed, err := deckStore.Rekey(cfg.CleanupBatchSize)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("encrypted %d decks with key %q\n", rekeyed, cfg.Encryption.PrimaryKeyID())
+	return nil
+}
@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/lando-ke/card-api/controllers"
+	"github.com/lando-ke/card-api/database"
+	"github.com/lando-ke/card-api/fsck"
+	"github.com/lando-ke/card-api/store"
+	"gorm.io/gorm"
+)
+
+// RegisterAdminRoutes mounts the maintenance endpoints under /admin. They
+// are only mounted when an admin token is configured. cache is the deck cache
+// of the server and actors its deck actors, either of which may be nil, and
+// backupDir the directory backups are written to, or "" to turn backups off.
+func RegisterAdminRoutes(r *gin.Engine, db *gorm.DB, token string, cache *store.CachedDeckStore, actors *store.ActorDeckStore, backupDir string, opts ...fsck.Option) {
+	if token == "" {
+		return
+	}
+
+	var backups *database.Backups
+	if backupDir != "" {
+		backups = database.NewBackups(db, backupDir)
+	}
+	adminController := controllers.NewAdminController(fsck.NewChecker(db, opts...), token, cache, actors, backups)
+	admin := r.Group("/admin", adminController.Authorize)
+	admin.GET("/fsck", adminController.Check)
+	admin.POST("/fsck", adminController.Repair)
+	admin.GET("/cache", adminController.CacheStats)
+	admin.POST("/backup", adminController.Backup)
+}
@@ -2,13 +2,59 @@ package routes
 
 import (
 	"github.com/lando-ke/card-api/controllers"
+	"github.com/lando-ke/card-api/store"
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
-func RegisterDeckRoutes(r *gin.Engine, db *gorm.DB) {
-	deckController := controllers.NewDeckController(db)
-	r.POST("/deck", deckController.CreateDeck)
-	r.GET("/deck/:deck_id", deckController.OpenDeck)
-	r.GET("/deck/:deck_id/draw", deckController.DrawCard)
+// apiVersion is one version of the deck API, mounted under its prefix. A
+// new version that changes the shape of responses gets a controller and
+// routes of its own next to the older ones, which keep answering as before.
+type apiVersion struct {
+	prefix string
+	mount  func(g *gin.RouterGroup, deckStore store.DeckStore, opts ...controllers.Option)
+}
+
+// apiVersions lists the versions of the deck API, oldest first.
+var apiVersions = []apiVersion{
+	{prefix: "/v1", mount: mountV1},
+}
+
+// legacyVersion is the version the unversioned paths such as /deck answer
+// as. They are deprecated in favor of its prefix.
+const legacyVersion = "/v1"
+
+// RegisterDeckRoutes mounts every version of the deck API, and the
+// unversioned paths as deprecated aliases of legacyVersion.
+func RegisterDeckRoutes(r *gin.Engine, deckStore store.DeckStore, opts ...controllers.Option) {
+	for _, v := range apiVersions {
+		v.mount(r.Group(v.prefix), deckStore, opts...)
+		if v.prefix == legacyVersion {
+			v.mount(r.Group("", deprecated(v.prefix)), deckStore, opts...)
+		}
+	}
+}
+
+func mountV1(g *gin.RouterGroup, deckStore store.DeckStore, opts ...controllers.Option) {
+	deckController := controllers.NewDeckController(deckStore, opts...)
+	g.POST("/deck", deckController.CreateDeck)
+	g.POST("/deck/import", deckController.ImportDeck)
+	g.GET("/deck/:deck_id", deckController.OpenDeck)
+	g.GET("/deck/:deck_id/draw", deckController.DrawCard)
+	g.POST("/deck/:deck_id/shuffle", deckController.ShuffleDeck)
+	g.POST("/deck/:deck_id/undo", deckController.UndoDeck)
+	g.GET("/deck/:deck_id/history", deckController.History)
+	g.GET("/deck/:deck_id/export", deckController.ExportDeck)
+	g.POST("/deck/:deck_id/snapshots", deckController.SaveSnapshot)
+	g.GET("/deck/:deck_id/snapshots", deckController.ListSnapshots)
+	g.POST("/deck/:deck_id/snapshots/:name/restore", deckController.RestoreSnapshot)
+}
+
+// deprecated marks the responses of an unversioned path as deprecated and
+// links to the same path under successor.
+func deprecated(successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+c.Request.URL.Path+`>; rel="successor-version"`)
+		c.Next()
+	}
 }
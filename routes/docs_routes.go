@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lando-ke/card-api/docs"
+)
+
+// RegisterDocsRoutes serves the OpenAPI specification at /openapi.json and
+// the documentation page at /docs.
+func RegisterDocsRoutes(r *gin.Engine) {
+	r.GET("/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", docs.OpenAPI)
+	})
+	r.GET("/docs", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", docs.Page)
+	})
+}
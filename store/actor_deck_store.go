@@ -0,0 +1,526 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lando-ke/card-api/models"
+)
+
+var (
+	// ErrDeckBusy is returned when the mailbox of a deck is full.
+	ErrDeckBusy = errors.New("deck is busy, try again")
+	// ErrStoreClosed is returned once an ActorDeckStore has been closed.
+	ErrStoreClosed = errors.New("deck store is closed")
+)
+
+// ActorDeckStore runs the operations on each active deck through a goroutine
+// of its own, the deck's actor, which keeps the deck in memory and is the
+// only one to change it. Changes are answered from memory and written to the
+// backing store afterwards, once the actor has no more messages waiting or
+// enough changes have piled up (see maxPendingWrites and maxWriteDelay); any
+// operation that reads from the backing store, such as History, first waits
+// for the writes of the deck before it. An actor that has been idle for the
+// idle timeout writes what is left and stops.
+//
+// The backing store must not be changed by anyone else while the
+// ActorDeckStore is in use, and writes that have not reached it are lost if
+// the process dies. A write the backing store refuses is kept and tried
+// again with backoff; until it succeeds the deck answers ErrDeckBusy, so that
+// no operation is answered from a state that may never be stored.
+type ActorDeckStore struct {
+	backing     DeckStore
+	mailboxSize int
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	actors map[string]*deckActor
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// deckActor owns one deck. Only its goroutine touches the fields below
+// inbox.
+type deckActor struct {
+	store  *ActorDeckStore
+	deckID string
+	inbox  chan func(*deckActor)
+
+	// deck is the current state of the deck, nil until it is loaded.
+	deck *models.Deck
+	// known holds the codes of every card of the deck, in it or not, so
+	// that updates may put drawn cards back.
+	known   map[string]bool
+	pending []pendingWrite
+	// pendingSince is when the oldest of pending was made.
+	pendingSince time.Time
+	// retry fires when the next attempt to write pending is due, after the
+	// backing store refused it. It is nil while writes succeed.
+	retry      *time.Timer
+	retryDelay time.Duration
+}
+
+const (
+	// maxPendingWrites and maxWriteDelay bound how many changes a busy
+	// actor holds, and for how long, before it writes them while messages
+	// are still waiting.
+	maxPendingWrites = 32
+	maxWriteDelay    = 100 * time.Millisecond
+	// minRetryDelay and maxRetryDelay bound the backoff between attempts
+	// to write changes the backing store refused.
+	minRetryDelay = 10 * time.Millisecond
+	maxRetryDelay = 5 * time.Second
+	// flushTimeout is how long Flush and DeleteExpired wait for busy
+	// actors.
+	flushTimeout = time.Second
+	// closeAttempts is how many times an actor tries to write its changes
+	// when the store is closed before it gives them up.
+	closeAttempts = 5
+)
+
+// pendingWrite is a change made in memory that has not reached the backing
+// store yet.
+type pendingWrite struct {
+	deck models.Deck
+	op   models.DeckOperation
+}
+
+// NewActorDeckStore puts actors in front of backing. Each deck accepts up to
+// mailboxSize operations waiting for its actor before it answers ErrDeckBusy,
+// and its actor stops after being idle for idleTimeout.
+func NewActorDeckStore(backing DeckStore, mailboxSize int, idleTimeout time.Duration) *ActorDeckStore {
+	return &ActorDeckStore{
+		backing:     backing,
+		mailboxSize: mailboxSize,
+		idleTimeout: idleTimeout,
+		actors:      make(map[string]*deckActor),
+	}
+}
+
+// Create writes the deck to the backing store right away; it has no actor
+// until it is used.
+func (s *ActorDeckStore) Create(deck *models.Deck, op models.DeckOperation) error {
+	return s.backing.Create(deck, op)
+}
+
+func (s *ActorDeckStore) Get(deckID string) (models.Deck, error) {
+	var deck models.Deck
+	err := s.call(deckID, func(a *deckActor) error {
+		if err := a.load(); err != nil {
+			return err
+		}
+		deck = copyDeck(*a.deck)
+		return nil
+	})
+	return deck, err
+}
+
+func (s *ActorDeckStore) Draw(deckID string, count int) ([]models.Card, error) {
+	var drawn []models.Card
+	if _, err := s.Update(deckID, DrawFunc(count, &drawn)); err != nil {
+		return nil, err
+	}
+	return drawn, nil
+}
+
+func (s *ActorDeckStore) Update(deckID string, fn UpdateFunc) (models.Deck, error) {
+	var deck models.Deck
+	err := s.call(deckID, func(a *deckActor) error {
+		var err error
+		deck, err = a.update(fn)
+		return err
+	})
+	return deck, err
+}
+
+// List reads the backing store, so decks with writes still to come may be
+// listed in an earlier state.
+func (s *ActorDeckStore) List(offset, limit int) ([]models.Deck, error) {
+	return s.backing.List(offset, limit)
+}
+
+func (s *ActorDeckStore) History(deckID string) ([]models.DeckOperation, error) {
+	var history []models.DeckOperation
+	err := s.callFlushed(deckID, func(*deckActor) (err error) {
+		history, err = s.backing.History(deckID)
+		return err
+	})
+	return history, err
+}
+
+func (s *ActorDeckStore) SaveSnapshot(snapshot *models.DeckSnapshot) error {
+	return s.callFlushed(snapshot.DeckID, func(*deckActor) error {
+		return s.backing.SaveSnapshot(snapshot)
+	})
+}
+
+func (s *ActorDeckStore) Snapshots(deckID string) ([]models.DeckSnapshot, error) {
+	var snapshots []models.DeckSnapshot
+	err := s.callFlushed(deckID, func(*deckActor) (err error) {
+		snapshots, err = s.backing.Snapshots(deckID)
+		return err
+	})
+	return snapshots, err
+}
+
+func (s *ActorDeckStore) Snapshot(deckID, name string) (models.DeckSnapshot, error) {
+	var snapshot models.DeckSnapshot
+	err := s.callFlushed(deckID, func(*deckActor) (err error) {
+		snapshot, err = s.backing.Snapshot(deckID, name)
+		return err
+	})
+	return snapshot, err
+}
+
+// DeleteExpired first makes every actor write its changes, and those of
+// expired decks forget the deck, so that no deck is deleted while its actor
+// holds it or has changes for it. When an actor cannot write its changes no
+// deck is deleted, and the error is ErrDeckBusy.
+func (s *ActorDeckStore) DeleteExpired(now time.Time, idleTTL time.Duration, limit int) (int, error) {
+	err := s.visit(func(a *deckActor) {
+		if a.deck != nil && a.deck.Expired(now, idleTTL) {
+			a.deck = nil
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return s.backing.DeleteExpired(now, idleTTL, limit)
+}
+
+// Flush makes every actor write its changes to the backing store, for
+// instance before the backing store is backed up. It fails with ErrDeckBusy
+// when an actor cannot, because its mailbox stays full or the backing store
+// refuses its writes.
+func (s *ActorDeckStore) Flush() error {
+	return s.visit(func(*deckActor) {})
+}
+
+// visit runs fn on every actor once it has written its changes. An actor
+// that is busy is asked again until flushTimeout has passed.
+func (s *ActorDeckStore) visit(fn func(*deckActor)) error {
+	s.mu.Lock()
+	deckIDs := make([]string, 0, len(s.actors))
+	for deckID := range s.actors {
+		deckIDs = append(deckIDs, deckID)
+	}
+	s.mu.Unlock()
+
+	deadline := time.Now().Add(flushTimeout)
+	for _, deckID := range deckIDs {
+		for {
+			err := s.callFlushed(deckID, func(a *deckActor) error {
+				fn(a)
+				return nil
+			})
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, ErrDeckBusy) || time.Now().After(deadline) {
+				return fmt.Errorf("deck %s: %w", deckID, err)
+			}
+			time.Sleep(minRetryDelay)
+		}
+	}
+	return nil
+}
+
+// Close stops every actor once it has written its changes. Calls made after
+// Close fail with ErrStoreClosed.
+func (s *ActorDeckStore) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		for _, a := range s.actors {
+			close(a.inbox)
+		}
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// call runs fn on the actor of deckID and waits for its result.
+func (s *ActorDeckStore) call(deckID string, fn func(*deckActor) error) error {
+	result := make(chan error, 1)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrStoreClosed
+	}
+	a, ok := s.actors[deckID]
+	if !ok {
+		a = s.spawn(deckID)
+	}
+	// Sending while holding mu keeps an idle actor from stopping between
+	// the lookup and the send.
+	select {
+	case a.inbox <- func(a *deckActor) {
+		// A deck whose changes cannot be written takes no more.
+		if a.retry != nil {
+			result <- ErrDeckBusy
+			return
+		}
+		result <- fn(a)
+	}:
+	default:
+		s.mu.Unlock()
+		return ErrDeckBusy
+	}
+	s.mu.Unlock()
+
+	return <-result
+}
+
+// callFlushed runs fn on the actor of deckID once the changes of the deck
+// have been written to the backing store.
+func (s *ActorDeckStore) callFlushed(deckID string, fn func(*deckActor) error) error {
+	return s.call(deckID, func(a *deckActor) error {
+		if !a.save() {
+			return ErrDeckBusy
+		}
+		return fn(a)
+	})
+}
+
+// spawn starts the actor of deckID. It must be called with mu held.
+func (s *ActorDeckStore) spawn(deckID string) *deckActor {
+	a := &deckActor{store: s, deckID: deckID, inbox: make(chan func(*deckActor), s.mailboxSize)}
+	s.actors[deckID] = a
+
+	s.wg.Add(1)
+	go a.run()
+	return a
+}
+
+func (a *deckActor) run() {
+	defer a.store.wg.Done()
+
+	idle := time.NewTimer(a.store.idleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case msg, ok := <-a.inbox:
+			if !ok {
+				a.drain()
+				return
+			}
+			msg(a)
+			if a.due() {
+				a.save()
+			}
+			// An actor that holds nothing, such as one asked for a deck
+			// that does not exist, stops right away.
+			if a.deck == nil && len(a.pending) == 0 && a.retire() {
+				return
+			}
+
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(a.store.idleTimeout)
+
+		case <-a.retryC():
+			a.retry = nil
+			a.save()
+
+		case <-idle.C:
+			a.save()
+			if a.retire() {
+				return
+			}
+			idle.Reset(a.store.idleTimeout)
+		}
+	}
+}
+
+// due reports whether the pending changes should be written now. Writes wait
+// until the mailbox is empty, so that a burst of operations is answered
+// without waiting for the database, but no longer than maxPendingWrites
+// changes or maxWriteDelay.
+func (a *deckActor) due() bool {
+	if len(a.pending) == 0 {
+		return false
+	}
+	return len(a.inbox) == 0 || len(a.pending) >= maxPendingWrites || time.Since(a.pendingSince) >= maxWriteDelay
+}
+
+// retryC returns the channel of the retry timer, or nil when no retry is
+// due.
+func (a *deckActor) retryC() <-chan time.Time {
+	if a.retry == nil {
+		return nil
+	}
+	return a.retry.C
+}
+
+// retire removes the actor from the store unless messages arrived for it in
+// the meantime or it has changes left to write.
+func (a *deckActor) retire() bool {
+	a.store.mu.Lock()
+	defer a.store.mu.Unlock()
+
+	if len(a.inbox) > 0 || len(a.pending) > 0 || a.store.closed {
+		return false
+	}
+	delete(a.store.actors, a.deckID)
+	return true
+}
+
+// load reads the deck from the backing store unless the actor holds it.
+func (a *deckActor) load() error {
+	if a.deck != nil {
+		return nil
+	}
+
+	deck, err := a.store.backing.Get(a.deckID)
+	if err != nil {
+		return err
+	}
+	history, err := a.store.backing.History(a.deckID)
+	if err != nil {
+		return err
+	}
+
+	a.known = make(map[string]bool, len(deck.Cards))
+	for _, card := range deck.Cards {
+		a.known[card.Code] = true
+	}
+	for _, op := range history {
+		for _, code := range strings.Split(op.Cards, ",") {
+			if code != "" {
+				a.known[code] = true
+			}
+		}
+	}
+
+	a.deck = &deck
+	return nil
+}
+
+// update applies fn to the deck in memory and queues the result to be
+// written.
+func (a *deckActor) update(fn UpdateFunc) (models.Deck, error) {
+	if err := a.load(); err != nil {
+		return models.Deck{}, err
+	}
+
+	deck := copyDeck(*a.deck)
+	op, err := fn(&deck)
+	if err != nil {
+		return models.Deck{}, err
+	}
+	if err := checkCards(a.deckID, deck.Cards, a.known); err != nil {
+		return models.Deck{}, err
+	}
+
+	now := time.Now()
+	for i := range deck.Cards {
+		deck.Cards[i].DeckID = a.deckID
+		deck.Cards[i].Position = i
+		if deck.Cards[i].State != models.CardInDeck {
+			deck.Cards[i].State, deck.Cards[i].Holder, deck.Cards[i].StateChangedAt = models.CardInDeck, "", &now
+		}
+	}
+	deck.Remaining = len(deck.Cards)
+	deck.Version++
+	deck.UpdatedAt = now
+	op.Shuffled = deck.Shuffled
+
+	stored := copyDeck(deck)
+	a.deck = &stored
+	if len(a.pending) == 0 {
+		a.pendingSince = now
+	}
+	a.pending = append(a.pending, pendingWrite{deck: copyDeck(deck), op: op})
+
+	return deck, nil
+}
+
+// save writes the pending changes and reports whether none are left. When
+// the backing store refuses them they are kept, and another attempt is
+// scheduled with a longer delay than the last one.
+func (a *deckActor) save() bool {
+	if a.retry != nil {
+		return false
+	}
+
+	err := a.flush()
+	if err == nil {
+		a.retryDelay = 0
+		return true
+	}
+	if errors.Is(err, ErrDeckNotFound) {
+		// The deck was deleted, and its changes with it.
+		log.Printf("deck %s: deleted with %d unsaved operations", a.deckID, len(a.pending))
+		a.deck, a.pending = nil, nil
+		return true
+	}
+
+	a.retryDelay *= 2
+	if a.retryDelay < minRetryDelay {
+		a.retryDelay = minRetryDelay
+	}
+	if a.retryDelay > maxRetryDelay {
+		a.retryDelay = maxRetryDelay
+	}
+	log.Printf("deck %s: cannot write %d operations, retrying in %v: %v", a.deckID, len(a.pending), a.retryDelay, err)
+	a.retry = time.NewTimer(a.retryDelay)
+	return false
+}
+
+// drain writes the pending changes before the actor stops, giving up on
+// them only after closeAttempts failed attempts.
+func (a *deckActor) drain() {
+	if a.retry != nil {
+		a.retry.Stop()
+	}
+
+	delay := minRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := a.flush()
+		if err == nil {
+			return
+		}
+		if attempt == closeAttempts {
+			log.Printf("deck %s: losing %d unsaved operations: %v", a.deckID, len(a.pending), err)
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// flush writes the pending changes to the backing store in order, and stops
+// at the first one it refuses.
+func (a *deckActor) flush() error {
+	for len(a.pending) > 0 {
+		w := a.pending[0]
+		if _, err := a.store.backing.Update(a.deckID, persistFunc(w)); err != nil {
+			return err
+		}
+		a.pending = a.pending[1:]
+	}
+	return nil
+}
+
+// persistFunc returns an UpdateFunc that gives a stored deck the state of w
+// and records its operation.
+func persistFunc(w pendingWrite) UpdateFunc {
+	return func(deck *models.Deck) (models.DeckOperation, error) {
+		if deck.Version+1 != w.deck.Version {
+			return models.DeckOperation{}, fmt.Errorf("deck is at version %d, cannot write version %d", deck.Version, w.deck.Version)
+		}
+
+		deck.Cards = append([]models.Card{}, w.deck.Cards...)
+		deck.Shuffled = w.deck.Shuffled
+		return w.op, nil
+	}
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/lando-ke/card-api/models"
+)
+
+// CachedDeckStore keeps the decks read most recently in memory, up to a
+// fixed number of them, and answers Get from there. Every change made
+// through it drops the deck from the cache, so the cache never answers with
+// a deck older than the last change; changes made to the backing store by
+// anyone else are not seen until the deck leaves the cache.
+type CachedDeckStore struct {
+	backing DeckStore
+	size    int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the cached decks, the most recently used in front.
+	lru *list.List
+	// epoch counts the changes made through the store. A deck read from
+	// the backing store is only cached when no change was made while it was
+	// read, so that an old deck does not replace the invalidated one.
+	epoch  uint64
+	hits   uint64
+	misses uint64
+}
+
+// CacheStats describes the use of a CachedDeckStore.
+type CacheStats struct {
+	Size     int     `json:"size"`
+	Capacity int     `json:"capacity"`
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRate  float64 `json:"hit_rate"`
+}
+
+// NewCachedDeckStore caches up to size decks of backing.
+func NewCachedDeckStore(backing DeckStore, size int) *CachedDeckStore {
+	return &CachedDeckStore{
+		backing: backing,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (s *CachedDeckStore) Create(deck *models.Deck, op models.DeckOperation) error {
+	defer s.invalidate(deck.DeckID)
+	return s.backing.Create(deck, op)
+}
+
+func (s *CachedDeckStore) Get(deckID string) (models.Deck, error) {
+	s.mu.Lock()
+	if e, ok := s.entries[deckID]; ok {
+		s.lru.MoveToFront(e)
+		s.hits++
+		deck := copyDeck(*e.Value.(*models.Deck))
+		s.mu.Unlock()
+		return deck, nil
+	}
+	s.misses++
+	epoch := s.epoch
+	s.mu.Unlock()
+
+	deck, err := s.backing.Get(deckID)
+	if err != nil {
+		return models.Deck{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if epoch == s.epoch {
+		s.put(deck)
+	}
+	return deck, nil
+}
+
+func (s *CachedDeckStore) Draw(deckID string, count int) ([]models.Card, error) {
+	defer s.invalidate(deckID)
+	return s.backing.Draw(deckID, count)
+}
+
+func (s *CachedDeckStore) Update(deckID string, fn UpdateFunc) (models.Deck, error) {
+	defer s.invalidate(deckID)
+	return s.backing.Update(deckID, fn)
+}
+
+func (s *CachedDeckStore) List(offset, limit int) ([]models.Deck, error) {
+	return s.backing.List(offset, limit)
+}
+
+func (s *CachedDeckStore) History(deckID string) ([]models.DeckOperation, error) {
+	return s.backing.History(deckID)
+}
+
+func (s *CachedDeckStore) SaveSnapshot(snapshot *models.DeckSnapshot) error {
+	return s.backing.SaveSnapshot(snapshot)
+}
+
+func (s *CachedDeckStore) Snapshots(deckID string) ([]models.DeckSnapshot, error) {
+	return s.backing.Snapshots(deckID)
+}
+
+func (s *CachedDeckStore) Snapshot(deckID, name string) (models.DeckSnapshot, error) {
+	return s.backing.Snapshot(deckID, name)
+}
+
+// DeleteExpired empties the cache when it deletes any deck, as it does not
+// tell which ones it deleted.
+func (s *CachedDeckStore) DeleteExpired(now time.Time, idleTTL time.Duration, limit int) (int, error) {
+	deleted, err := s.backing.DeleteExpired(now, idleTTL, limit)
+	if deleted > 0 {
+		s.Purge()
+	}
+	return deleted, err
+}
+
+// Purge empties the cache.
+func (s *CachedDeckStore) Purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.epoch++
+	s.entries = make(map[string]*list.Element)
+	s.lru.Init()
+}
+
+// Stats returns the number of cached decks and how often Get found the deck
+// in the cache.
+func (s *CachedDeckStore) Stats() CacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := CacheStats{Size: s.lru.Len(), Capacity: s.size, Hits: s.hits, Misses: s.misses}
+	if total := s.hits + s.misses; total > 0 {
+		stats.HitRate = float64(s.hits) / float64(total)
+	}
+	return stats
+}
+
+// invalidate drops deckID from the cache.
+func (s *CachedDeckStore) invalidate(deckID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.epoch++
+	if e, ok := s.entries[deckID]; ok {
+		s.lru.Remove(e)
+		delete(s.entries, deckID)
+	}
+}
+
+// put caches a copy of deck, evicting the least recently used deck when the
+// cache is full. It must be called with mu held.
+func (s *CachedDeckStore) put(deck models.Deck) {
+	if s.size < 1 {
+		return
+	}
+	if e, ok := s.entries[deck.DeckID]; ok {
+		s.lru.Remove(e)
+	}
+	if s.lru.Len() >= s.size {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*models.Deck).DeckID)
+	}
+
+	stored := copyDeck(deck)
+	s.entries[deck.DeckID] = s.lru.PushFront(&stored)
+}
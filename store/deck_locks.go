@@ -0,0 +1,45 @@
+package store
+
+import "sync"
+
+// deckLocks serializes operations on the same deck within the process while
+// letting operations on different decks run in parallel.
+type deckLocks struct {
+	mu    sync.Mutex
+	locks map[string]*deckLock
+}
+
+type deckLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newDeckLocks() *deckLocks {
+	return &deckLocks{locks: make(map[string]*deckLock)}
+}
+
+// lock blocks until the caller holds the lock of deckID and returns the
+// function that releases it.
+func (l *deckLocks) lock(deckID string) func() {
+	l.mu.Lock()
+	dl, ok := l.locks[deckID]
+	if !ok {
+		dl = &deckLock{}
+		l.locks[deckID] = dl
+	}
+	dl.refs++
+	l.mu.Unlock()
+
+	dl.Lock()
+
+	return func() {
+		dl.Unlock()
+
+		l.mu.Lock()
+		dl.refs--
+		if dl.refs == 0 {
+			delete(l.locks, deckID)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lando-ke/card-api/models"
+)
+
+var (
+	ErrDeckNotFound     = errors.New("deck not found")
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+)
+
+// NotEnoughCardsError is returned when a draw asks for more cards than the
+// deck has left.
+type NotEnoughCardsError struct {
+	Remaining int
+}
+
+func (e *NotEnoughCardsError) Error() string {
+	return fmt.Sprintf("not enough cards in deck, only %d remaining", e.Remaining)
+}
+
+// VersionMismatchError is returned when an update was made conditional on a
+// version of the deck that is no longer current.
+type VersionMismatchError struct {
+	Version int
+}
+
+func (e *VersionMismatchError) Error() string {
+	return fmt.Sprintf("deck has changed, current version is %d", e.Version)
+}
+
+// UpdateFunc changes a deck in place and returns the operation to record in
+// the deck's history.
+type UpdateFunc func(deck *models.Deck) (models.DeckOperation, error)
+
+// DeckStore keeps decks, the cards they hold and their history. Every
+// implementation serializes the operations on one deck, so an UpdateFunc
+// always sees the latest state of the deck it changes.
+type DeckStore interface {
+	// Create stores deck with deck.Cards in draw order and records op as
+	// the first entry of its history.
+	Create(deck *models.Deck, op models.DeckOperation) error
+	// Get returns the deck with its remaining cards in draw order.
+	Get(deckID string) (models.Deck, error)
+	// Draw removes the top count cards from the deck and returns them.
+	Draw(deckID string, count int) ([]models.Card, error)
+	// Update loads the deck with its remaining cards, applies fn and stores
+	// the result with the next version. deck.Cards may be reordered, and
+	// cards of the deck may be removed from it or put back. Removed cards
+	// become models.CardDrawn, held by the Holder of the operation fn
+	// returns; cards put back become models.CardInDeck again.
+	Update(deckID string, fn UpdateFunc) (models.Deck, error)
+	// List returns up to limit decks, oldest first, without their cards.
+	List(offset, limit int) ([]models.Deck, error)
+	// History returns the operations recorded for a deck, oldest first.
+	History(deckID string) ([]models.DeckOperation, error)
+	// SaveSnapshot stores snapshot for its deck, replacing the deck's
+	// snapshot of the same name.
+	SaveSnapshot(snapshot *models.DeckSnapshot) error
+	// Snapshots returns the snapshots of a deck, oldest first.
+	Snapshots(deckID string) ([]models.DeckSnapshot, error)
+	// Snapshot returns the snapshot of a deck with the given name.
+	Snapshot(deckID, name string) (models.DeckSnapshot, error)
+	// DeleteExpired permanently deletes up to limit decks that have expired
+	// at now, with their cards, history and snapshots, and returns how many
+	// it deleted.
+	DeleteExpired(now time.Time, idleTTL time.Duration, limit int) (int, error)
+}
+
+// IfVersion returns an UpdateFunc that applies fn only while the deck is at
+// one of versions, and fails with a VersionMismatchError otherwise.
+func IfVersion(versions []int, fn UpdateFunc) UpdateFunc {
+	return func(deck *models.Deck) (models.DeckOperation, error) {
+		for _, version := range versions {
+			if deck.Version == version {
+				return fn(deck)
+			}
+		}
+		return models.DeckOperation{}, &VersionMismatchError{Version: deck.Version}
+	}
+}
+
+// HeldBy returns an UpdateFunc that applies fn and hands the cards it takes
+// off the deck to holder.
+func HeldBy(holder string, fn UpdateFunc) UpdateFunc {
+	return func(deck *models.Deck) (models.DeckOperation, error) {
+		op, err := fn(deck)
+		op.Holder = holder
+		return op, err
+	}
+}
+
+// DrawFunc returns an UpdateFunc that takes the top count cards off a deck
+// and hands them to drawn.
+func DrawFunc(count int, drawn *[]models.Card) UpdateFunc {
+	return func(deck *models.Deck) (models.DeckOperation, error) {
+		if count > len(deck.Cards) {
+			return models.DeckOperation{}, &NotEnoughCardsError{Remaining: len(deck.Cards)}
+		}
+
+		*drawn = append([]models.Card{}, deck.Cards[:count]...)
+		deck.Cards = deck.Cards[count:]
+
+		return models.NewDrawOperation(*drawn, deck.Cards), nil
+	}
+}
+
+// checkCards makes sure cards only holds cards of the deck, each at most once.
+func checkCards(deckID string, cards []models.Card, known map[string]bool) error {
+	seen := make(map[string]bool, len(cards))
+	for _, card := range cards {
+		if !known[card.Code] {
+			return fmt.Errorf("card %s is not part of deck %s", card.Code, deckID)
+		}
+		if seen[card.Code] {
+			return fmt.Errorf("card %s appears twice in deck %s", card.Code, deckID)
+		}
+		seen[card.Code] = true
+	}
+	return nil
+}
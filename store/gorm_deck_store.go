@@ -0,0 +1,487 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lando-ke/card-api/encryption"
+	"github.com/lando-ke/card-api/models"
+	"gorm.io/gorm"
+)
+
+// createBatchSize is the number of cards inserted per statement, which keeps
+// the bound parameters of a statement below SQLite's limit.
+const createBatchSize = 100
+
+// GormDeckStore keeps decks in a SQL database. By default every card is a
+// row of its own; with WithCompactStorage new decks keep their whole card
+// order in a single column of the deck row instead. Decks stored either way
+// can be read and changed by any GormDeckStore.
+type GormDeckStore struct {
+	db      *gorm.DB
+	locks   *deckLocks
+	compact bool
+	keys    *encryption.Keyring
+}
+
+// GormOption configures a GormDeckStore.
+type GormOption func(*GormDeckStore)
+
+// WithCompactStorage stores new decks in the compact layout: one encoded
+// column instead of a row per card.
+func WithCompactStorage() GormOption {
+	return func(s *GormDeckStore) {
+		s.compact = true
+	}
+}
+
+// WithEncryption encrypts the cards of decks, their history and their
+// snapshots under the primary key of keys before they are written. Data
+// written without encryption or under an older key stays readable, and can
+// be encrypted under the primary key with Rekey.
+func WithEncryption(keys *encryption.Keyring) GormOption {
+	return func(s *GormDeckStore) {
+		s.keys = keys
+	}
+}
+
+func NewGormDeckStore(db *gorm.DB, opts ...GormOption) *GormDeckStore {
+	s := &GormDeckStore{db: db, locks: newDeckLocks()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Create inserts the deck, its cards and the first history entry in one
+// transaction, so a failure never leaves a deck with only some of its cards.
+func (s *GormDeckStore) Create(deck *models.Deck, op models.DeckOperation) error {
+	cards := deck.Cards
+	deck.Cards = nil
+	deck.Remaining = len(cards)
+	deck.Version = 1
+
+	if s.compact {
+		order, err := models.EncodeCardOrder(cards, nil)
+		if err != nil {
+			return err
+		}
+		deck.CardOrder = order
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.createDeck(tx, deck); err != nil {
+			return err
+		}
+
+		for i := range cards {
+			cards[i].DeckID = deck.DeckID
+			cards[i].Position = i
+			cards[i].State = models.CardInDeck
+		}
+		if len(cards) > 0 && deck.CardOrder == nil {
+			if err := s.createCards(tx, cards); err != nil {
+				return err
+			}
+		}
+
+		op.Shuffled = deck.Shuffled
+		return s.recordOperation(tx, deck.DeckID, op)
+	})
+	if err != nil {
+		return err
+	}
+
+	deck.Cards = cards
+	return nil
+}
+
+func (s *GormDeckStore) Get(deckID string) (models.Deck, error) {
+	var deck models.Deck
+	if err := s.findDeck(s.db, deckID, &deck); err != nil {
+		return models.Deck{}, err
+	}
+
+	if deck.CardOrder != nil {
+		remaining, _, err := models.DecodeCardOrder(deck.CardOrder)
+		if err != nil {
+			return models.Deck{}, err
+		}
+		deck.Cards = withPositions(deckID, remaining)
+		return deck, nil
+	}
+
+	if err := s.db.Where("deck_id = ? AND state = ?", deckID, models.CardInDeck).Order("position ASC").Find(&deck.Cards).Error; err != nil {
+		return models.Deck{}, err
+	}
+	if err := s.decryptCards(deck.Cards); err != nil {
+		return models.Deck{}, err
+	}
+
+	return deck, nil
+}
+
+func (s *GormDeckStore) Draw(deckID string, count int) ([]models.Card, error) {
+	var drawn []models.Card
+	if _, err := s.Update(deckID, DrawFunc(count, &drawn)); err != nil {
+		return nil, err
+	}
+	return drawn, nil
+}
+
+// Update runs in a single transaction and is serialized with every other
+// update of the same deck, so concurrent draws never hand out the same card
+// twice.
+func (s *GormDeckStore) Update(deckID string, fn UpdateFunc) (models.Deck, error) {
+	unlock := s.locks.lock(deckID)
+	defer unlock()
+
+	var deck models.Deck
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.findDeck(tx, deckID, &deck); err != nil {
+			return err
+		}
+
+		update := s.updateRows
+		if deck.CardOrder != nil {
+			update = updateCompact
+		}
+		op, err := update(tx, &deck, fn)
+		if err != nil {
+			return err
+		}
+
+		cardOrder, err := s.keys.Encrypt(deck.CardOrder)
+		if err != nil {
+			return err
+		}
+		deck.Remaining = len(deck.Cards)
+		deck.Version++
+		err = tx.Model(&models.Deck{}).Where("deck_id = ?", deckID).Updates(map[string]interface{}{
+			"remaining":  deck.Remaining,
+			"shuffled":   deck.Shuffled,
+			"version":    deck.Version,
+			"card_order": cardOrder,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		op.Shuffled = deck.Shuffled
+		return s.recordOperation(tx, deckID, op)
+	})
+	if err != nil {
+		return models.Deck{}, err
+	}
+
+	return deck, nil
+}
+
+func (s *GormDeckStore) List(offset, limit int) ([]models.Deck, error) {
+	var decks []models.Deck
+	if err := s.db.Order("created_at ASC, deck_id ASC").Offset(offset).Limit(limit).Find(&decks).Error; err != nil {
+		return nil, err
+	}
+	for i := range decks {
+		if err := s.decryptCardOrder(&decks[i]); err != nil {
+			return nil, err
+		}
+	}
+	return decks, nil
+}
+
+func (s *GormDeckStore) History(deckID string) ([]models.DeckOperation, error) {
+	var deck models.Deck
+	if err := findDeck(s.db, deckID, &deck); err != nil {
+		return nil, err
+	}
+
+	var operations []models.DeckOperation
+	if err := s.db.Where("deck_id = ?", deckID).Order("seq ASC").Find(&operations).Error; err != nil {
+		return nil, err
+	}
+	for i := range operations {
+		if err := s.decryptOperation(&operations[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return operations, nil
+}
+
+func (s *GormDeckStore) SaveSnapshot(snapshot *models.DeckSnapshot) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var deck models.Deck
+		if err := findDeck(tx, snapshot.DeckID, &deck); err != nil {
+			return err
+		}
+		if err := tx.Where("deck_id = ? AND name = ?", snapshot.DeckID, snapshot.Name).Delete(&models.DeckSnapshot{}).Error; err != nil {
+			return err
+		}
+
+		stored := *snapshot
+		if err := s.encryptSnapshot(&stored); err != nil {
+			return err
+		}
+		if err := tx.Create(&stored).Error; err != nil {
+			return err
+		}
+		snapshot.ID, snapshot.CreatedAt = stored.ID, stored.CreatedAt
+		return nil
+	})
+}
+
+func (s *GormDeckStore) Snapshots(deckID string) ([]models.DeckSnapshot, error) {
+	var deck models.Deck
+	if err := findDeck(s.db, deckID, &deck); err != nil {
+		return nil, err
+	}
+
+	var snapshots []models.DeckSnapshot
+	if err := s.db.Where("deck_id = ?", deckID).Order("id ASC").Find(&snapshots).Error; err != nil {
+		return nil, err
+	}
+	for i := range snapshots {
+		if err := s.decryptSnapshot(&snapshots[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return snapshots, nil
+}
+
+func (s *GormDeckStore) Snapshot(deckID, name string) (models.DeckSnapshot, error) {
+	var deck models.Deck
+	if err := findDeck(s.db, deckID, &deck); err != nil {
+		return models.DeckSnapshot{}, err
+	}
+
+	var snapshot models.DeckSnapshot
+	err := s.db.Where("deck_id = ? AND name = ?", deckID, name).First(&snapshot).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.DeckSnapshot{}, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return models.DeckSnapshot{}, err
+	}
+	if err := s.decryptSnapshot(&snapshot); err != nil {
+		return models.DeckSnapshot{}, err
+	}
+
+	return snapshot, nil
+}
+
+func (s *GormDeckStore) DeleteExpired(now time.Time, idleTTL time.Duration, limit int) (int, error) {
+	query := s.db.Model(&models.Deck{}).Where("expires_at IS NOT NULL AND expires_at <= ?", now)
+	if idleTTL > 0 {
+		query = query.Or("expires_at IS NULL AND updated_at <= ?", now.Add(-idleTTL))
+	}
+
+	var deckIDs []string
+	if err := query.Limit(limit).Pluck("deck_id", &deckIDs).Error; err != nil {
+		return 0, err
+	}
+	if len(deckIDs) == 0 {
+		return 0, nil
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("deck_id IN ?", deckIDs).Delete(&models.Card{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("deck_id IN ?", deckIDs).Delete(&models.DeckOperation{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("deck_id IN ?", deckIDs).Delete(&models.DeckSnapshot{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("deck_id IN ?", deckIDs).Delete(&models.Deck{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(deckIDs), nil
+}
+
+// updateRows applies fn to a deck stored as card rows and writes the new
+// card order back to the rows.
+func (s *GormDeckStore) updateRows(tx *gorm.DB, deck *models.Deck, fn UpdateFunc) (models.DeckOperation, error) {
+	// Cards that left the deck are loaded too, so that fn may put them back.
+	var rows []models.Card
+	if err := tx.Where("deck_id = ?", deck.DeckID).Order("position ASC").Find(&rows).Error; err != nil {
+		return models.DeckOperation{}, err
+	}
+	if err := s.decryptCards(rows); err != nil {
+		return models.DeckOperation{}, err
+	}
+
+	known := make(map[string]bool, len(rows))
+	byCode := make(map[string]*models.Card, len(rows))
+	for i := range rows {
+		known[rows[i].Code] = true
+		byCode[rows[i].Code] = &rows[i]
+		if rows[i].State == models.CardInDeck {
+			deck.Cards = append(deck.Cards, rows[i])
+		}
+	}
+
+	op, err := fn(deck)
+	if err != nil {
+		return models.DeckOperation{}, err
+	}
+	if err := checkCards(deck.DeckID, deck.Cards, known); err != nil {
+		return models.DeckOperation{}, err
+	}
+
+	if err := s.saveCardOrder(tx, deck.Cards, byCode, op.Holder); err != nil {
+		return models.DeckOperation{}, err
+	}
+	for i, card := range deck.Cards {
+		deck.Cards[i] = *byCode[card.Code]
+	}
+
+	return op, nil
+}
+
+// updateCompact applies fn to a deck stored in the compact layout and
+// encodes the new card order into deck.CardOrder.
+func updateCompact(tx *gorm.DB, deck *models.Deck, fn UpdateFunc) (models.DeckOperation, error) {
+	remaining, drawn, err := models.DecodeCardOrder(deck.CardOrder)
+	if err != nil {
+		return models.DeckOperation{}, err
+	}
+
+	known := make(map[string]bool, len(remaining)+len(drawn))
+	for _, card := range append(append([]models.Card{}, remaining...), drawn...) {
+		known[card.Code] = true
+	}
+
+	deck.Cards = withPositions(deck.DeckID, remaining)
+	op, err := fn(deck)
+	if err != nil {
+		return models.DeckOperation{}, err
+	}
+	if err := checkCards(deck.DeckID, deck.Cards, known); err != nil {
+		return models.DeckOperation{}, err
+	}
+
+	// Cards put back leave the drawn pile, cards taken out join it in the
+	// order they were drawn.
+	live := make(map[string]bool, len(deck.Cards))
+	for _, card := range deck.Cards {
+		live[card.Code] = true
+	}
+	stillDrawn := []models.Card{}
+	for _, card := range append(drawn, remaining...) {
+		if !live[card.Code] {
+			stillDrawn = append(stillDrawn, card)
+		}
+	}
+
+	deck.Cards = withPositions(deck.DeckID, deck.Cards)
+	deck.CardOrder, err = models.EncodeCardOrder(deck.Cards, stillDrawn)
+	if err != nil {
+		return models.DeckOperation{}, err
+	}
+
+	return op, nil
+}
+
+// withPositions numbers cards in draw order as the cards in deckID.
+func withPositions(deckID string, cards []models.Card) []models.Card {
+	for i := range cards {
+		cards[i].DeckID = deckID
+		cards[i].Position = i
+		cards[i].State = models.CardInDeck
+	}
+	return cards
+}
+
+func findDeck(db *gorm.DB, deckID string, deck *models.Deck) error {
+	err := db.Where("deck_id = ?", deckID).First(deck).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrDeckNotFound
+	}
+	return err
+}
+
+// saveCardOrder writes the order of cards back to their rows in byCode and
+// marks every row that is no longer in cards as drawn by holder. Positions
+// are only rewritten when the stored ones no longer follow the new order, so
+// a plain draw leaves the remaining rows untouched. Encrypted cards are moved
+// between the rows instead, see sealCardOrder.
+func (s *GormDeckStore) saveCardOrder(tx *gorm.DB, cards []models.Card, byCode map[string]*models.Card, holder string) error {
+	now := time.Now()
+	inOrder := true
+	for i := 1; i < len(cards); i++ {
+		if byCode[cards[i].Code].Position <= byCode[cards[i-1].Code].Position {
+			inOrder = false
+			break
+		}
+	}
+	if !inOrder && s.keys != nil {
+		if err := s.sealCardOrder(tx, cards, byCode, now); err != nil {
+			return err
+		}
+		inOrder = true
+	}
+
+	live := make(map[string]bool, len(cards))
+	for i, card := range cards {
+		live[card.Code] = true
+		row := byCode[card.Code]
+
+		updates := map[string]interface{}{}
+		if !inOrder && row.Position != i {
+			row.Position = i
+			updates["position"] = i
+		}
+		if row.State != models.CardInDeck {
+			row.State, row.Holder, row.StateChangedAt = models.CardInDeck, "", &now
+			updates["state"] = row.State
+			updates["holder"] = row.Holder
+			updates["state_changed_at"] = row.StateChangedAt
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		if err := tx.Model(&models.Card{}).Where("id = ?", row.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+
+	ids := []uint{}
+	for code, row := range byCode {
+		if !live[code] && row.State == models.CardInDeck {
+			row.State, row.Holder, row.StateChangedAt = models.CardDrawn, holder, &now
+			ids = append(ids, row.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return tx.Model(&models.Card{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+		"state":            models.CardDrawn,
+		"holder":           holder,
+		"state_changed_at": now,
+	}).Error
+}
+
+// recordOperation appends op to the event log of the deck. It must run in
+// the transaction that changes the deck, so that the log and the deck never
+// disagree.
+func (s *GormDeckStore) recordOperation(tx *gorm.DB, deckID string, op models.DeckOperation) error {
+	var last int
+	if err := tx.Model(&models.DeckOperation{}).Where("deck_id = ?", deckID).Select("COALESCE(MAX(seq), 0)").Scan(&last).Error; err != nil {
+		return err
+	}
+
+	op.DeckID = deckID
+	op.Seq = last + 1
+	if err := s.encryptOperation(&op); err != nil {
+		return err
+	}
+	return tx.Create(&op).Error
+}
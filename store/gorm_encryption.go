@@ -0,0 +1,404 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lando-ke/card-api/models"
+	"gorm.io/gorm"
+)
+
+// ErrNoEncryption is returned by Rekey for a store without a keyring.
+var ErrNoEncryption = errors.New("the deck store has no encryption keys")
+
+// Rekey encrypts the data of every deck under the primary key, including
+// data written before encryption was turned on or under an older key, so
+// that older keys can be removed from the keyring afterwards. Card rows whose
+// IDs give away the order of their deck are rewritten as sealCardOrder
+// writes them. It works through the decks batchSize at a time, each in a
+// transaction of its own, and returns the number of decks it had to rewrite.
+func (s *GormDeckStore) Rekey(batchSize int) (int, error) {
+	if s.keys == nil {
+		return 0, ErrNoEncryption
+	}
+
+	rekeyed := 0
+	lastID := ""
+	for {
+		var deckIDs []string
+		err := s.db.Unscoped().Model(&models.Deck{}).Where("deck_id > ?", lastID).Order("deck_id ASC").Limit(batchSize).Pluck("deck_id", &deckIDs).Error
+		if err != nil {
+			return rekeyed, err
+		}
+		if len(deckIDs) == 0 {
+			return rekeyed, nil
+		}
+
+		for _, deckID := range deckIDs {
+			changed, err := s.rekeyDeck(deckID)
+			if err != nil {
+				return rekeyed, err
+			}
+			if changed {
+				rekeyed++
+			}
+		}
+		lastID = deckIDs[len(deckIDs)-1]
+	}
+}
+
+// rekeyDeck encrypts the data of one deck under the primary key and reports
+// whether any of it had to be rewritten. Columns are updated without
+// touching updated_at, so that rekeying does not keep idle decks alive.
+func (s *GormDeckStore) rekeyDeck(deckID string) (bool, error) {
+	unlock := s.locks.lock(deckID)
+	defer unlock()
+
+	changed := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var deck models.Deck
+		if err := tx.Unscoped().Select("deck_id", "card_order").Where("deck_id = ?", deckID).First(&deck).Error; err != nil {
+			return err
+		}
+		if !s.keys.Current(deck.CardOrder) {
+			order, err := s.keys.Decrypt(deck.CardOrder)
+			if err != nil {
+				return err
+			}
+			if order, err = s.keys.Encrypt(order); err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Model(&models.Deck{}).Where("deck_id = ?", deckID).UpdateColumn("card_order", order).Error; err != nil {
+				return err
+			}
+			changed = true
+		}
+
+		var cards []models.Card
+		if err := tx.Unscoped().Where("deck_id = ?", deckID).Find(&cards).Error; err != nil {
+			return err
+		}
+		for i := range cards {
+			if s.current(cards[i].Value, cards[i].Suit, cards[i].Code) {
+				continue
+			}
+			if err := s.decryptCards(cards[i : i+1]); err != nil {
+				return err
+			}
+			card, err := s.encryptCard(cards[i])
+			if err != nil {
+				return err
+			}
+			err = tx.Unscoped().Model(&models.Card{}).Where("id = ?", card.ID).UpdateColumns(map[string]interface{}{
+				"value": card.Value,
+				"suit":  card.Suit,
+				"code":  card.Code,
+			}).Error
+			if err != nil {
+				return err
+			}
+			changed = true
+		}
+
+		// Decks shuffled before cards moved between rows still give away
+		// their order through the IDs of their rows.
+		var live []models.Card
+		if err := tx.Where("deck_id = ? AND state = ?", deckID, models.CardInDeck).Order("position ASC").Find(&live).Error; err != nil {
+			return err
+		}
+		if !sort.SliceIsSorted(live, func(i, j int) bool { return live[i].ID < live[j].ID }) {
+			if err := s.decryptCards(live); err != nil {
+				return err
+			}
+			byCode := make(map[string]*models.Card, len(live))
+			for i := range live {
+				byCode[live[i].Code] = &live[i]
+			}
+			if err := s.sealCardOrder(tx, live, byCode, time.Now()); err != nil {
+				return err
+			}
+			changed = true
+		}
+
+		var operations []models.DeckOperation
+		if err := tx.Where("deck_id = ?", deckID).Find(&operations).Error; err != nil {
+			return err
+		}
+		for _, op := range operations {
+			if !op.Seeded() && s.current(op.Cards, op.Fingerprint, op.SealedSeed) {
+				continue
+			}
+			if err := s.decryptOperation(&op); err != nil {
+				return err
+			}
+			if err := s.encryptOperation(&op); err != nil {
+				return err
+			}
+			err := tx.Model(&models.DeckOperation{}).Where("id = ?", op.ID).UpdateColumns(map[string]interface{}{
+				"cards":        op.Cards,
+				"fingerprint":  op.Fingerprint,
+				"seed":         nil,
+				"shuffle_seed": "",
+				"sealed_seed":  op.SealedSeed,
+			}).Error
+			if err != nil {
+				return err
+			}
+			changed = true
+		}
+
+		var snapshots []models.DeckSnapshot
+		if err := tx.Where("deck_id = ?", deckID).Find(&snapshots).Error; err != nil {
+			return err
+		}
+		for _, snapshot := range snapshots {
+			if s.current(snapshot.Cards, snapshot.Drawn, snapshot.Fingerprint) {
+				continue
+			}
+			if err := s.decryptSnapshot(&snapshot); err != nil {
+				return err
+			}
+			if err := s.encryptSnapshot(&snapshot); err != nil {
+				return err
+			}
+			err := tx.Model(&models.DeckSnapshot{}).Where("id = ?", snapshot.ID).UpdateColumns(map[string]interface{}{
+				"cards":       snapshot.Cards,
+				"drawn":       snapshot.Drawn,
+				"fingerprint": snapshot.Fingerprint,
+			}).Error
+			if err != nil {
+				return err
+			}
+			changed = true
+		}
+
+		return nil
+	})
+	return changed, err
+}
+
+// sealCardOrder writes the new order of encrypted cards to their rows in
+// byCode. Giving rows new positions would tie each row, and the ID it was
+// inserted with in the order the deck was created, to a place in the deck.
+// Instead the rows keep their IDs in position order and the cards move
+// between them, every one encrypted again, so that neither IDs nor positions
+// nor unchanged ciphertexts tell the order. byCode is updated to the rows
+// the cards end up in.
+func (s *GormDeckStore) sealCardOrder(tx *gorm.DB, cards []models.Card, byCode map[string]*models.Card, now time.Time) error {
+	rows := make([]models.Card, len(cards))
+	for i, card := range cards {
+		rows[i] = *byCode[card.Code]
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+
+	for i, card := range cards {
+		source := byCode[card.Code]
+		row := &rows[i]
+		row.Value, row.Suit, row.Code = source.Value, source.Suit, source.Code
+		row.Position = i
+		row.State, row.Holder, row.StateChangedAt = models.CardInDeck, "", source.StateChangedAt
+		if source.State != models.CardInDeck {
+			row.StateChangedAt = &now
+		}
+
+		sealed, err := s.encryptCard(*row)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.Card{}).Where("id = ?", row.ID).Updates(map[string]interface{}{
+			"value":            sealed.Value,
+			"suit":             sealed.Suit,
+			"code":             sealed.Code,
+			"position":         row.Position,
+			"state":            row.State,
+			"holder":           row.Holder,
+			"state_changed_at": row.StateChangedAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range rows {
+		byCode[rows[i].Code] = &rows[i]
+	}
+	return nil
+}
+
+// current reports whether every value is encrypted under the primary key.
+func (s *GormDeckStore) current(values ...string) bool {
+	for _, value := range values {
+		if !s.keys.Current([]byte(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// findDeck finds a deck and decrypts its card order.
+func (s *GormDeckStore) findDeck(db *gorm.DB, deckID string, deck *models.Deck) error {
+	if err := findDeck(db, deckID, deck); err != nil {
+		return err
+	}
+	return s.decryptCardOrder(deck)
+}
+
+// createDeck inserts deck with its card order encrypted, leaving the plain
+// card order in deck.
+func (s *GormDeckStore) createDeck(tx *gorm.DB, deck *models.Deck) error {
+	order := deck.CardOrder
+	sealed, err := s.keys.Encrypt(order)
+	if err != nil {
+		return err
+	}
+
+	deck.CardOrder = sealed
+	err = tx.Create(deck).Error
+	deck.CardOrder = order
+	return err
+}
+
+// createCards inserts cards with their values encrypted, leaving the plain
+// values in cards.
+func (s *GormDeckStore) createCards(tx *gorm.DB, cards []models.Card) error {
+	if s.keys == nil {
+		return tx.CreateInBatches(cards, createBatchSize).Error
+	}
+
+	rows := make([]models.Card, len(cards))
+	for i, card := range cards {
+		row, err := s.encryptCard(card)
+		if err != nil {
+			return err
+		}
+		rows[i] = row
+	}
+	if err := tx.CreateInBatches(rows, createBatchSize).Error; err != nil {
+		return err
+	}
+
+	for i := range cards {
+		cards[i].Model = rows[i].Model
+	}
+	return nil
+}
+
+func (s *GormDeckStore) decryptCardOrder(deck *models.Deck) error {
+	order, err := s.keys.Decrypt(deck.CardOrder)
+	if err != nil {
+		return err
+	}
+	deck.CardOrder = order
+	return nil
+}
+
+func (s *GormDeckStore) encryptCard(card models.Card) (models.Card, error) {
+	var err error
+	for _, value := range []*string{&card.Value, &card.Suit, &card.Code} {
+		if *value, err = s.keys.EncryptString(*value); err != nil {
+			return models.Card{}, err
+		}
+	}
+	return card, nil
+}
+
+// decryptCards decrypts the values of cards in place.
+func (s *GormDeckStore) decryptCards(cards []models.Card) error {
+	var err error
+	for i := range cards {
+		for _, value := range []*string{&cards[i].Value, &cards[i].Suit, &cards[i].Code} {
+			if *value, err = s.keys.DecryptString(*value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encryptOperation encrypts the cards and the fingerprint of op, and moves
+// its seed, which would give away the order of the deck it shuffled, to
+// SealedSeed. A fingerprint is a plain hash of the card order, so the order
+// of a few cards can be found from it by trying every one.
+func (s *GormDeckStore) encryptOperation(op *models.DeckOperation) error {
+	if s.keys == nil {
+		return nil
+	}
+
+	var err error
+	if op.Cards, err = s.keys.EncryptString(op.Cards); err != nil {
+		return err
+	}
+	if op.Fingerprint, err = s.keys.EncryptString(op.Fingerprint); err != nil {
+		return err
+	}
+	if op.ShuffleSeed != "" {
+		if op.SealedSeed, err = s.keys.EncryptString(op.ShuffleSeed); err != nil {
+			return err
+		}
+		op.ShuffleSeed = ""
+	}
+	if op.Seed != nil {
+		if op.SealedSeed, err = s.keys.EncryptString(strconv.FormatInt(*op.Seed, 10)); err != nil {
+			return err
+		}
+		op.Seed = nil
+	}
+	return nil
+}
+
+func (s *GormDeckStore) decryptOperation(op *models.DeckOperation) error {
+	var err error
+	if op.Cards, err = s.keys.DecryptString(op.Cards); err != nil {
+		return err
+	}
+	if op.Fingerprint, err = s.keys.DecryptString(op.Fingerprint); err != nil {
+		return err
+	}
+	if op.SealedSeed == "" {
+		return nil
+	}
+
+	sealed, err := s.keys.DecryptString(op.SealedSeed)
+	if err != nil {
+		return err
+	}
+	op.SealedSeed = ""
+	// The 64 bit seeds of older shuffles are sealed in decimal, which is
+	// never as long as a hex encoded 32 byte seed.
+	if len(sealed) == 2*32 {
+		op.ShuffleSeed = sealed
+		return nil
+	}
+	seed, err := strconv.ParseInt(sealed, 10, 64)
+	if err != nil {
+		return err
+	}
+	op.Seed = &seed
+	return nil
+}
+
+func (s *GormDeckStore) encryptSnapshot(snapshot *models.DeckSnapshot) error {
+	var err error
+	if snapshot.Cards, err = s.keys.EncryptString(snapshot.Cards); err != nil {
+		return err
+	}
+	if snapshot.Fingerprint, err = s.keys.EncryptString(snapshot.Fingerprint); err != nil {
+		return err
+	}
+	snapshot.Drawn, err = s.keys.EncryptString(snapshot.Drawn)
+	return err
+}
+
+func (s *GormDeckStore) decryptSnapshot(snapshot *models.DeckSnapshot) error {
+	var err error
+	if snapshot.Cards, err = s.keys.DecryptString(snapshot.Cards); err != nil {
+		return err
+	}
+	if snapshot.Fingerprint, err = s.keys.DecryptString(snapshot.Fingerprint); err != nil {
+		return err
+	}
+	snapshot.Drawn, err = s.keys.DecryptString(snapshot.Drawn)
+	return err
+}
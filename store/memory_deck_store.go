@@ -0,0 +1,249 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lando-ke/card-api/models"
+)
+
+// MemoryDeckStore keeps decks in process memory. It suits tests and services
+// that embed the deck logic without a database; nothing survives a restart.
+type MemoryDeckStore struct {
+	mu     sync.Mutex
+	decks  map[string]*memoryDeck
+	order  []string
+	nextID uint
+}
+
+type memoryDeck struct {
+	deck      models.Deck
+	drawn     []models.Card
+	history   []models.DeckOperation
+	snapshots []models.DeckSnapshot
+}
+
+func NewMemoryDeckStore() *MemoryDeckStore {
+	return &MemoryDeckStore{decks: make(map[string]*memoryDeck)}
+}
+
+func (s *MemoryDeckStore) Create(deck *models.Deck, op models.DeckOperation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.nextID++
+	deck.ID = s.nextID
+	deck.CreatedAt = now
+	deck.UpdatedAt = now
+	deck.Remaining = len(deck.Cards)
+	deck.Version = 1
+	for i := range deck.Cards {
+		deck.Cards[i].DeckID = deck.DeckID
+		deck.Cards[i].Position = i
+		deck.Cards[i].State = models.CardInDeck
+	}
+
+	stored := &memoryDeck{deck: copyDeck(*deck)}
+	s.decks[deck.DeckID] = stored
+	s.order = append(s.order, deck.DeckID)
+	op.Shuffled = deck.Shuffled
+	s.record(stored, op, now)
+
+	return nil
+}
+
+func (s *MemoryDeckStore) Get(deckID string) (models.Deck, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.decks[deckID]
+	if !ok {
+		return models.Deck{}, ErrDeckNotFound
+	}
+
+	return copyDeck(stored.deck), nil
+}
+
+func (s *MemoryDeckStore) Draw(deckID string, count int) ([]models.Card, error) {
+	var drawn []models.Card
+	if _, err := s.Update(deckID, DrawFunc(count, &drawn)); err != nil {
+		return nil, err
+	}
+	return drawn, nil
+}
+
+func (s *MemoryDeckStore) Update(deckID string, fn UpdateFunc) (models.Deck, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.decks[deckID]
+	if !ok {
+		return models.Deck{}, ErrDeckNotFound
+	}
+
+	deck := copyDeck(stored.deck)
+	op, err := fn(&deck)
+	if err != nil {
+		return models.Deck{}, err
+	}
+
+	known := make(map[string]bool)
+	for _, card := range stored.deck.Cards {
+		known[card.Code] = true
+	}
+	for _, card := range stored.drawn {
+		known[card.Code] = true
+	}
+	if err := checkCards(deckID, deck.Cards, known); err != nil {
+		return models.Deck{}, err
+	}
+
+	now := time.Now()
+	live := make(map[string]bool, len(deck.Cards))
+	for i := range deck.Cards {
+		deck.Cards[i].Position = i
+		if deck.Cards[i].State != models.CardInDeck {
+			deck.Cards[i].State, deck.Cards[i].Holder, deck.Cards[i].StateChangedAt = models.CardInDeck, "", &now
+		}
+		live[deck.Cards[i].Code] = true
+	}
+
+	// Cards put back leave the drawn pile, cards taken out join it in the
+	// order they were drawn.
+	drawn := []models.Card{}
+	for _, card := range stored.drawn {
+		if !live[card.Code] {
+			drawn = append(drawn, card)
+		}
+	}
+	for _, card := range stored.deck.Cards {
+		if !live[card.Code] {
+			card.State, card.Holder, card.StateChangedAt = models.CardDrawn, op.Holder, &now
+			drawn = append(drawn, card)
+		}
+	}
+
+	deck.Remaining = len(deck.Cards)
+	deck.Version++
+	deck.UpdatedAt = now
+	stored.deck = copyDeck(deck)
+	stored.drawn = drawn
+	op.Shuffled = deck.Shuffled
+	s.record(stored, op, now)
+
+	return deck, nil
+}
+
+func (s *MemoryDeckStore) List(offset, limit int) ([]models.Deck, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	decks := []models.Deck{}
+	for i := offset; i < len(s.order) && len(decks) < limit; i++ {
+		deck := s.decks[s.order[i]].deck
+		deck.Cards = nil
+		decks = append(decks, deck)
+	}
+
+	return decks, nil
+}
+
+func (s *MemoryDeckStore) History(deckID string) ([]models.DeckOperation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.decks[deckID]
+	if !ok {
+		return nil, ErrDeckNotFound
+	}
+
+	return append([]models.DeckOperation{}, stored.history...), nil
+}
+
+func (s *MemoryDeckStore) SaveSnapshot(snapshot *models.DeckSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.decks[snapshot.DeckID]
+	if !ok {
+		return ErrDeckNotFound
+	}
+
+	s.nextID++
+	snapshot.ID = s.nextID
+	snapshot.CreatedAt = time.Now()
+
+	snapshots := []models.DeckSnapshot{}
+	for _, existing := range stored.snapshots {
+		if existing.Name != snapshot.Name {
+			snapshots = append(snapshots, existing)
+		}
+	}
+	stored.snapshots = append(snapshots, *snapshot)
+
+	return nil
+}
+
+func (s *MemoryDeckStore) Snapshots(deckID string) ([]models.DeckSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.decks[deckID]
+	if !ok {
+		return nil, ErrDeckNotFound
+	}
+
+	return append([]models.DeckSnapshot{}, stored.snapshots...), nil
+}
+
+func (s *MemoryDeckStore) Snapshot(deckID, name string) (models.DeckSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.decks[deckID]
+	if !ok {
+		return models.DeckSnapshot{}, ErrDeckNotFound
+	}
+
+	for _, snapshot := range stored.snapshots {
+		if snapshot.Name == name {
+			return snapshot, nil
+		}
+	}
+
+	return models.DeckSnapshot{}, ErrSnapshotNotFound
+}
+
+func (s *MemoryDeckStore) DeleteExpired(now time.Time, idleTTL time.Duration, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	order := []string{}
+	for _, deckID := range s.order {
+		if deleted < limit && s.decks[deckID].deck.Expired(now, idleTTL) {
+			delete(s.decks, deckID)
+			deleted++
+			continue
+		}
+		order = append(order, deckID)
+	}
+	s.order = order
+
+	return deleted, nil
+}
+
+func (s *MemoryDeckStore) record(stored *memoryDeck, op models.DeckOperation, now time.Time) {
+	op.ID = uint(len(stored.history) + 1)
+	op.Seq = len(stored.history) + 1
+	op.DeckID = stored.deck.DeckID
+	op.CreatedAt = now
+	stored.history = append(stored.history, op)
+}
+
+// copyDeck returns a copy of deck that shares no cards with it.
+func copyDeck(deck models.Deck) models.Deck {
+	deck.Cards = append([]models.Card{}, deck.Cards...)
+	return deck
+}
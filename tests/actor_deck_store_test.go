@@ -0,0 +1,289 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActorDeckStore_WriteBehind(t *testing.T) {
+	backing := store.NewGormDeckStore(setupDB())
+	actors := store.NewActorDeckStore(backing, 64, time.Minute)
+	defer actors.Close()
+
+	deck, _ := utils.NewDeck(actors, false, "AS,KH,2D,JC,10C")
+	if _, err := actors.Update(deck.DeckID, store.HeldBy("alice", store.DrawFunc(2, new([]models.Card)))); err != nil {
+		t.Fatalf("failed to draw: %v", err)
+	}
+
+	// Reading the history waits for the draw to be written.
+	history, err := actors.History(deck.DeckID)
+	if err != nil {
+		t.Fatalf("failed to load history: %v", err)
+	}
+	assert.Len(t, history, 2)
+	assert.Equal(t, "alice", history[1].Holder)
+
+	stored, _ := backing.Get(deck.DeckID)
+	assertCodes(t, stored.Cards, "2D", "JC", "10C")
+	assert.Equal(t, 2, stored.Version)
+}
+
+func TestActorDeckStore_ConcurrentDraws(t *testing.T) {
+	backing := store.NewGormDeckStore(setupDB())
+	actors := store.NewActorDeckStore(backing, 64, time.Minute)
+	deck, _ := utils.NewDeck(actors, true, "")
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	drawn := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				cards, err := actors.Draw(deck.DeckID, 1)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				drawn[cards[0].Code]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	actors.Close()
+
+	assert.Len(t, drawn, 52)
+	for code, times := range drawn {
+		assert.Equal(t, 1, times, "card %s was drawn more than once", code)
+	}
+
+	// Close wrote every draw.
+	stored, _ := backing.Get(deck.DeckID)
+	assert.Equal(t, 0, stored.Remaining)
+	assert.Equal(t, 53, stored.Version)
+}
+
+func TestActorDeckStore_Busy(t *testing.T) {
+	actors := store.NewActorDeckStore(store.NewMemoryDeckStore(), 1, time.Minute)
+	defer actors.Close()
+	deck, _ := utils.NewDeck(actors, false, "AS,KH,2D")
+
+	// Hold the actor in an update until release is closed.
+	started, release := make(chan struct{}), make(chan struct{})
+	go actors.Update(deck.DeckID, func(deck *models.Deck) (models.DeckOperation, error) {
+		close(started)
+		<-release
+		return models.NewDeckOperation(models.MethodShuffle, len(deck.Cards), nil, deck.Cards), nil
+	})
+	<-started
+
+	// One request fits in the mailbox, the next one is turned away.
+	queued := make(chan error)
+	go func() {
+		_, err := actors.Get(deck.DeckID)
+		queued <- err
+	}()
+	var err error
+	for i := 0; i < 100 && !errors.Is(err, store.ErrDeckBusy); i++ {
+		time.Sleep(time.Millisecond)
+		_, err = actors.Get(deck.DeckID)
+	}
+	assert.ErrorIs(t, err, store.ErrDeckBusy)
+
+	close(release)
+	assert.NoError(t, <-queued)
+}
+
+func TestActorDeckStore_IdleEviction(t *testing.T) {
+	backing := store.NewMemoryDeckStore()
+	actors := store.NewActorDeckStore(backing, 64, 10*time.Millisecond)
+	defer actors.Close()
+
+	deck, _ := utils.NewDeck(actors, false, "AS,KH,2D")
+	actors.Draw(deck.DeckID, 1)
+
+	// The idle actor writes its draw and stops; the next request starts a
+	// new one that loads the deck again.
+	time.Sleep(50 * time.Millisecond)
+	stored, _ := backing.Get(deck.DeckID)
+	assertCodes(t, stored.Cards, "KH", "2D")
+
+	backing.Draw(deck.DeckID, 1)
+	reloaded, err := actors.Get(deck.DeckID)
+	if err != nil {
+		t.Fatalf("failed to get deck: %v", err)
+	}
+	assertCodes(t, reloaded.Cards, "2D")
+}
+
+func TestActorDeckStore_Closed(t *testing.T) {
+	actors := store.NewActorDeckStore(store.NewMemoryDeckStore(), 64, time.Minute)
+	deck, _ := utils.NewDeck(actors, false, "AS,KH,2D")
+	actors.Close()
+
+	_, err := actors.Get(deck.DeckID)
+	assert.ErrorIs(t, err, store.ErrStoreClosed)
+}
+
+// refusingDeckStore refuses every Update while refuse is set.
+type refusingDeckStore struct {
+	store.DeckStore
+
+	mu     sync.Mutex
+	refuse bool
+}
+
+func (s *refusingDeckStore) setRefuse(refuse bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refuse = refuse
+}
+
+func (s *refusingDeckStore) Update(deckID string, fn store.UpdateFunc) (models.Deck, error) {
+	s.mu.Lock()
+	refuse := s.refuse
+	s.mu.Unlock()
+	if refuse {
+		return models.Deck{}, errors.New("database is locked")
+	}
+	return s.DeckStore.Update(deckID, fn)
+}
+
+func TestActorDeckStore_RefusedWrite(t *testing.T) {
+	backing := &refusingDeckStore{DeckStore: store.NewMemoryDeckStore()}
+	actors := store.NewActorDeckStore(backing, 64, time.Minute)
+	defer actors.Close()
+	deck, _ := utils.NewDeck(actors, false, "AS,KH,2D")
+
+	backing.setRefuse(true)
+	drawn, err := actors.Draw(deck.DeckID, 1)
+	if err != nil {
+		t.Fatalf("failed to draw: %v", err)
+	}
+	assertCodes(t, drawn, "AS")
+
+	// The deck takes nothing more until its draw is written, rather than
+	// dealing the ace again.
+	_, err = actors.Draw(deck.DeckID, 1)
+	assert.ErrorIs(t, err, store.ErrDeckBusy)
+	_, err = actors.History(deck.DeckID)
+	assert.ErrorIs(t, err, store.ErrDeckBusy)
+
+	backing.setRefuse(false)
+	for i := 0; i < 100; i++ {
+		if drawn, err = actors.Draw(deck.DeckID, 1); !errors.Is(err, store.ErrDeckBusy) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("failed to draw once writes succeed: %v", err)
+	}
+	assertCodes(t, drawn, "KH")
+
+	history, _ := actors.History(deck.DeckID)
+	assert.Len(t, history, 3)
+	stored, _ := backing.Get(deck.DeckID)
+	assertCodes(t, stored.Cards, "2D")
+}
+
+func TestActorDeckStore_WritesWhileBusy(t *testing.T) {
+	backing := store.NewMemoryDeckStore()
+	actors := store.NewActorDeckStore(backing, 64, time.Minute)
+	defer actors.Close()
+	deck, _ := utils.NewDeck(actors, false, "")
+
+	// Hold the actor while a long burst of draws queues up behind it.
+	started, release := make(chan struct{}), make(chan struct{})
+	go actors.Update(deck.DeckID, func(deck *models.Deck) (models.DeckOperation, error) {
+		close(started)
+		<-release
+		return models.NewDeckOperation(models.MethodShuffle, len(deck.Cards), nil, deck.Cards), nil
+	})
+	<-started
+
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			actors.Draw(deck.DeckID, 1)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// The probe comes last and sees what had reached the backing store
+	// while the mailbox was never empty.
+	stored := make(chan int, 1)
+	go actors.Update(deck.DeckID, func(*models.Deck) (models.DeckOperation, error) {
+		deck, _ := backing.Get(deck.DeckID)
+		stored <- deck.Version
+		return models.DeckOperation{}, errors.New("probe")
+	})
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.GreaterOrEqual(t, <-stored, 32)
+}
+
+func TestActorDeckStore_UnknownDeck(t *testing.T) {
+	actors := store.NewActorDeckStore(store.NewMemoryDeckStore(), 64, time.Minute)
+	defer actors.Close()
+
+	// Asking for decks that do not exist leaves no actors behind.
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		_, err := actors.Get(fmt.Sprintf("missing-%d", i))
+		assert.ErrorIs(t, err, store.ErrDeckNotFound)
+	}
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
+func TestActorDeckStore_DeleteExpiredUnwritten(t *testing.T) {
+	backing := &refusingDeckStore{DeckStore: store.NewMemoryDeckStore()}
+	actors := store.NewActorDeckStore(backing, 64, time.Minute)
+	defer actors.Close()
+	deck, _ := utils.NewDeck(actors, false, "AS,KH,2D")
+
+	backing.setRefuse(true)
+	actors.Draw(deck.DeckID, 1)
+
+	// The draw has not been written, so nothing is deleted.
+	now := time.Now().Add(time.Second)
+	deleted, err := actors.DeleteExpired(now, time.Millisecond, 10)
+	assert.ErrorIs(t, err, store.ErrDeckBusy)
+	assert.Equal(t, 0, deleted)
+	if _, err := backing.Get(deck.DeckID); err != nil {
+		t.Fatalf("expected the deck to be kept, got %v", err)
+	}
+
+	// Once the draw is written the deck goes.
+	backing.setRefuse(false)
+	for i := 0; i < 300; i++ {
+		if _, err = actors.Get(deck.DeckID); !errors.Is(err, store.ErrDeckBusy) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	deleted, err = actors.DeleteExpired(time.Now().Add(time.Second), time.Millisecond, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	if _, err := actors.Get(deck.DeckID); !errors.Is(err, store.ErrDeckNotFound) {
+		t.Errorf("expected the actor to forget the deleted deck, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lando-ke/card-api/config"
+	"github.com/lando-ke/card-api/database"
+	"github.com/lando-ke/card-api/routes"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func openFileDB(t *testing.T, cfg config.DatabaseConfig) *gorm.DB {
+	db, err := database.InitDB(cfg)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.DatabaseConfig{Path: filepath.Join(dir, "card-api.db"), JournalMode: "WAL", BusyTimeout: 5 * time.Second}
+	db := openFileDB(t, cfg)
+
+	deckStore := store.NewGormDeckStore(db)
+	deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D")
+	deckStore.Draw(deck.DeckID, 1)
+
+	backupPath := filepath.Join(dir, "backup.db")
+	if err := database.Backup(db, backupPath); err != nil {
+		t.Fatalf("failed to back up: %v", err)
+	}
+	assert.Error(t, database.Backup(db, backupPath), "an existing backup is overwritten")
+
+	deckStore.Draw(deck.DeckID, 1)
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	version, err := database.Restore(cfg, backupPath)
+	if err != nil {
+		t.Fatalf("failed to restore: %v", err)
+	}
+	assert.Equal(t, database.LatestVersion(), version)
+
+	restored, err := store.NewGormDeckStore(openFileDB(t, cfg)).Get(deck.DeckID)
+	if err != nil {
+		t.Fatalf("failed to get restored deck: %v", err)
+	}
+	assertCodes(t, restored.Cards, "KH", "2D")
+}
+
+func TestRestore_ChecksBackup(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.DatabaseConfig{Path: filepath.Join(dir, "card-api.db")}
+
+	garbage := filepath.Join(dir, "garbage.db")
+	os.WriteFile(garbage, []byte("not a database at all, just some text"), 0o644)
+	_, err := database.Restore(cfg, garbage)
+	assert.ErrorIs(t, err, database.ErrInvalidBackup)
+
+	// A backup from a newer build is refused.
+	newer := config.DatabaseConfig{Path: filepath.Join(dir, "newer.db")}
+	db := openFileDB(t, newer)
+	db.Create(&database.SchemaMigration{Version: database.LatestVersion() + 1, Name: "from_the_future"})
+	_, err = database.Restore(cfg, newer.Path)
+	assert.ErrorIs(t, err, database.ErrInvalidBackup)
+
+	_, err = os.Stat(cfg.Path)
+	assert.ErrorIs(t, err, os.ErrNotExist, "a refused backup left a database behind")
+}
+
+func TestBackupEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	db := openFileDB(t, config.DatabaseConfig{Path: filepath.Join(dir, "card-api.db")})
+	r := gin.New()
+	routes.RegisterAdminRoutes(r, db, "secret", nil, nil, filepath.Join(dir, "backups"))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/admin/backup", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var backup database.BackupFile
+	json.Unmarshal(w.Body.Bytes(), &backup)
+	info, err := os.Stat(backup.Path)
+	if err != nil {
+		t.Fatalf("backup was not written: %v", err)
+	}
+	assert.Equal(t, info.Size(), backup.Size)
+}
+
+func TestBackupEndpoint_Actors(t *testing.T) {
+	dir := t.TempDir()
+	db := openFileDB(t, config.DatabaseConfig{Path: filepath.Join(dir, "card-api.db")})
+	backing := &refusingDeckStore{DeckStore: store.NewGormDeckStore(db)}
+	actors := store.NewActorDeckStore(backing, 64, time.Minute)
+	defer actors.Close()
+	r := gin.New()
+	routes.RegisterAdminRoutes(r, db, "secret", nil, actors, filepath.Join(dir, "backups"))
+
+	request := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/admin/backup", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	deck, _ := utils.NewDeck(actors, false, "AS,KH,2D")
+	backing.setRefuse(true)
+	actors.Draw(deck.DeckID, 1)
+
+	// The draw cannot be written, so neither can a backup that has it.
+	w := request()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	backing.setRefuse(false)
+	w = request()
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var backup database.BackupFile
+	json.Unmarshal(w.Body.Bytes(), &backup)
+	restored := openFileDB(t, config.DatabaseConfig{Path: backup.Path})
+	stored, err := store.NewGormDeckStore(restored).Get(deck.DeckID)
+	if err != nil {
+		t.Fatalf("failed to read the deck from the backup: %v", err)
+	}
+	assertCodes(t, stored.Cards, "KH", "2D")
+}
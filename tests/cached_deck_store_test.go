@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/routes"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachedDeckStore(t *testing.T) {
+	backing := store.NewMemoryDeckStore()
+	cache := store.NewCachedDeckStore(backing, 2)
+
+	deck, _ := utils.NewDeck(cache, false, "AS,KH,2D")
+	cache.Get(deck.DeckID)
+	cached, _ := cache.Get(deck.DeckID)
+	assertCodes(t, cached.Cards, "AS", "KH", "2D")
+	assert.Equal(t, store.CacheStats{Size: 1, Capacity: 2, Hits: 1, Misses: 1, HitRate: 0.5}, cache.Stats())
+
+	// Changing the returned deck leaves the cached one alone.
+	cached.Cards[0].Code = "ZZ"
+	again, _ := cache.Get(deck.DeckID)
+	assert.Equal(t, "AS", again.Cards[0].Code)
+
+	// Every change made through the cache invalidates the deck.
+	cache.Draw(deck.DeckID, 1)
+	drawn, _ := cache.Get(deck.DeckID)
+	assertCodes(t, drawn.Cards, "KH", "2D")
+	cache.Update(deck.DeckID, store.DrawFunc(1, new([]models.Card)))
+	updated, _ := cache.Get(deck.DeckID)
+	assertCodes(t, updated.Cards, "2D")
+
+	// Changes made to the backing store are not seen until a purge.
+	backing.Draw(deck.DeckID, 1)
+	stale, _ := cache.Get(deck.DeckID)
+	assert.Equal(t, 1, stale.Remaining)
+	cache.Purge()
+	fresh, _ := cache.Get(deck.DeckID)
+	assert.Equal(t, 0, fresh.Remaining)
+}
+
+func TestCachedDeckStore_Eviction(t *testing.T) {
+	cache := store.NewCachedDeckStore(store.NewMemoryDeckStore(), 2)
+	first, _ := utils.NewDeck(cache, false, "AS")
+	second, _ := utils.NewDeck(cache, false, "KH")
+	third, _ := utils.NewDeck(cache, false, "2D")
+
+	cache.Get(first.DeckID)
+	cache.Get(second.DeckID)
+	cache.Get(first.DeckID)
+	// The least recently used deck, second, makes room for third.
+	cache.Get(third.DeckID)
+	cache.Get(first.DeckID)
+	cache.Get(second.DeckID)
+
+	stats := cache.Stats()
+	assert.Equal(t, 2, stats.Size)
+	assert.Equal(t, uint64(2), stats.Hits)
+	assert.Equal(t, uint64(4), stats.Misses)
+}
+
+func TestCachedDeckStore_DeleteExpired(t *testing.T) {
+	cache := store.NewCachedDeckStore(store.NewMemoryDeckStore(), 2)
+	deck, _ := utils.NewDeck(cache, false, "AS")
+	cache.Get(deck.DeckID)
+
+	deleted, _ := cache.DeleteExpired(time.Now().Add(time.Hour), time.Minute, 10)
+	assert.Equal(t, 1, deleted)
+	_, err := cache.Get(deck.DeckID)
+	assert.ErrorIs(t, err, store.ErrDeckNotFound)
+}
+
+func TestCacheStatsEndpoint(t *testing.T) {
+	db := setupDB()
+	cache := store.NewCachedDeckStore(store.NewGormDeckStore(db), 8)
+	r := gin.New()
+	routes.RegisterDeckRoutes(r, cache)
+	routes.RegisterAdminRoutes(r, db, "secret", cache, nil, "")
+
+	deck, _ := utils.NewDeck(cache, false, "AS,KH")
+	for i := 0; i < 4; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/deck/"+deck.DeckID, nil))
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/admin/cache", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var stats store.CacheStats
+	json.Unmarshal(w.Body.Bytes(), &stats)
+	assert.Equal(t, uint64(3), stats.Hits)
+	assert.Equal(t, 0.75, stats.HitRate)
+}
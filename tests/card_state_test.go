@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lando-ke/card-api/controllers"
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/routes"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGormDeckStore_CardStates(t *testing.T) {
+	db := setupDB()
+	deckStore := store.NewGormDeckStore(db)
+
+	deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D")
+	var drawn []models.Card
+	if _, err := deckStore.Update(deck.DeckID, store.HeldBy("alice", store.DrawFunc(2, &drawn))); err != nil {
+		t.Fatalf("failed to draw: %v", err)
+	}
+
+	states := func() map[string]models.Card {
+		var cards []models.Card
+		db.Unscoped().Where("deck_id = ?", deck.DeckID).Find(&cards)
+		byCode := map[string]models.Card{}
+		for _, card := range cards {
+			assert.False(t, card.DeletedAt.Valid, "card %s is soft deleted", card.Code)
+			byCode[card.Code] = card
+		}
+		return byCode
+	}
+
+	cards := states()
+	for _, code := range []string{"AS", "KH"} {
+		assert.Equal(t, models.CardDrawn, cards[code].State)
+		assert.Equal(t, "alice", cards[code].Holder)
+		assert.NotNil(t, cards[code].StateChangedAt)
+	}
+	assert.Equal(t, models.CardInDeck, cards["2D"].State)
+	assert.Nil(t, cards["2D"].StateChangedAt)
+
+	opened, _ := deckStore.Get(deck.DeckID)
+	assertCodes(t, opened.Cards, "2D")
+
+	// Undoing the draw puts the cards back in the deck.
+	if _, err := utils.UndoDeck(deckStore, deck.DeckID, 1, 10); err != nil {
+		t.Fatalf("failed to undo: %v", err)
+	}
+	cards = states()
+	for _, code := range []string{"AS", "KH", "2D"} {
+		assert.Equal(t, models.CardInDeck, cards[code].State)
+		assert.Empty(t, cards[code].Holder)
+	}
+}
+
+func TestDrawCard_Holder(t *testing.T) {
+	r := gin.New()
+	deckStore := store.NewGormDeckStore(setupDB())
+	routes.RegisterDeckRoutes(r, deckStore)
+	deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/deck/"+deck.DeckID+"/draw?count=2&holder=seat-3", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/deck/"+deck.DeckID+"/history", nil))
+	var history controllers.HistoryResponse
+	json.Unmarshal(w.Body.Bytes(), &history)
+	assert.Len(t, history.History, 2)
+	assert.Equal(t, "seat-3", history.History[1].Holder)
+	assert.Empty(t, history.History[0].Holder)
+}
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lando-ke/card-api/config"
+	"github.com/lando-ke/card-api/database"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+)
+
+func TestDSN(t *testing.T) {
+	cfg := config.DatabaseConfig{Path: "decks.db", JournalMode: "WAL", BusyTimeout: 2 * time.Second}
+
+	cgoDSN := database.DSN(database.DriverSQLite, cfg)
+	if cgoDSN != "decks.db?_busy_timeout=2000&_journal_mode=WAL&_txlock=immediate" {
+		t.Errorf("unexpected DSN for the CGO driver: %s", cgoDSN)
+	}
+
+	pureGoDSN := database.DSN(database.DriverSQLitePureGo, cfg)
+	if pureGoDSN != "decks.db?_pragma=busy_timeout%282000%29&_pragma=journal_mode%28WAL%29&_txlock=immediate" {
+		t.Errorf("unexpected DSN for the pure Go driver: %s", pureGoDSN)
+	}
+
+	memoryDSN := database.DSN(database.DriverSQLite, config.DatabaseConfig{Path: ":memory:", JournalMode: "WAL"})
+	if memoryDSN != ":memory:?_busy_timeout=0&_txlock=immediate" {
+		t.Errorf("unexpected DSN for an in-memory database: %s", memoryDSN)
+	}
+}
+
+func TestInitDB(t *testing.T) {
+	for _, driver := range []string{database.DriverSQLite, database.DriverSQLitePureGo} {
+		for _, path := range []string{":memory:", filepath.Join(t.TempDir(), driver+".db")} {
+			t.Run(driver+" "+filepath.Base(path), func(t *testing.T) {
+				cfg := config.DatabaseConfig{Driver: driver, Path: path, JournalMode: "WAL", BusyTimeout: time.Second}
+
+				db, err := database.InitDB(cfg)
+				if err != nil {
+					t.Fatalf("failed to open database: %v", err)
+				}
+				if err := database.RunMigrations(db); err != nil {
+					t.Fatalf("failed to run migrations: %v", err)
+				}
+
+				deckStore := store.NewGormDeckStore(db)
+				deck, err := utils.NewDeck(deckStore, true, "")
+				if err != nil {
+					t.Fatalf("failed to create deck: %v", err)
+				}
+				if _, err := deckStore.Draw(deck.DeckID, 3); err != nil {
+					t.Fatalf("failed to draw: %v", err)
+				}
+
+				stored, err := deckStore.Get(deck.DeckID)
+				if err != nil {
+					t.Fatalf("failed to get deck: %v", err)
+				}
+				if stored.Remaining != 49 {
+					t.Errorf("expected 49 cards remaining, got %d", stored.Remaining)
+				}
+			})
+		}
+	}
+}
+
+func TestInitDB_UnknownDriver(t *testing.T) {
+	if _, err := database.InitDB(config.DatabaseConfig{Driver: "postgres", Path: ":memory:"}); err == nil {
+		t.Errorf("expected an error for an unknown driver")
+	}
+}
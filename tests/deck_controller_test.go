@@ -4,11 +4,14 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lando-ke/card-api/models"
 	"github.com/lando-ke/card-api/controllers"
+	"github.com/lando-ke/card-api/store"
 	"github.com/lando-ke/card-api/utils"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/sqlite"
@@ -23,13 +26,15 @@ func setupDB() *gorm.DB {
 
 	db.AutoMigrate(&models.Deck{})
 	db.AutoMigrate(&models.Card{})
+	db.AutoMigrate(&models.DeckOperation{})
+	db.AutoMigrate(&models.DeckSnapshot{})
 
 	return db
 }
 
 func TestCreateDeck(t *testing.T) {
-	db := setupDB()
-	deckController := controllers.NewDeckController(db)
+	deckStore := store.NewGormDeckStore(setupDB())
+	deckController := controllers.NewDeckController(deckStore)
 	gin.SetMode(gin.TestMode)
 
 	t.Run("create unshuffled deck", func(t *testing.T) {
@@ -149,16 +154,65 @@ func TestCreateDeck(t *testing.T) {
 
 		assert.Equal(t, "invalid cards values: KS (duplicate), KH (duplicate), XX", response["message"])
 	})
+
+	t.Run("create_stacked_deck", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		body := `{"cards": ["2C", "3D", "AS", "KS", "QS", "JS", "10S"], "strict": true}`
+		c.Request = httptest.NewRequest("POST", "/deck", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		deckController.CreateDeck(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response controllers.DeckResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.Equal(t, 7, response.Remaining)
+		assert.Equal(t, false, response.Shuffled)
+		expectedCodes := []string{"2C", "3D", "AS", "KS", "QS", "JS", "10S"}
+		for i, code := range expectedCodes {
+			assert.Equal(t, code, response.Cards[i].Code)
+		}
+	})
+
+	t.Run("create_stacked_deck_with_unknown_card", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		body := `{"cards": ["AS", "ZZ", "KS", "AS"], "strict": true}`
+		c.Request = httptest.NewRequest("POST", "/deck", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		deckController.CreateDeck(c)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var response gin.H
+		json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.Equal(t, "invalid cards values: ZZ, AS (duplicate)", response["message"])
+	})
+
+	t.Run("create_stacked_deck_shuffled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		body := `{"cards": ["AS", "KS"], "strict": true, "shuffled": true}`
+		c.Request = httptest.NewRequest("POST", "/deck", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		deckController.CreateDeck(c)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
 }
 
 func TestOpenDeck(t *testing.T) {
-	db := setupDB()
+	deckStore := store.NewGormDeckStore(setupDB())
 
-	dc := controllers.NewDeckController(db)
+	dc := controllers.NewDeckController(deckStore)
 
 	t.Run("open_full_deck", func(t *testing.T) {
 		// Create a deck first
-		deck, _ := utils.NewDeck(db, false, "")
+		deck, _ := utils.NewDeck(deckStore, false, "")
 	
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -181,7 +235,7 @@ func TestOpenDeck(t *testing.T) {
 	t.Run("open_partial_deck", func(t *testing.T) {
 		// Create a partial deck first
 		cardsParam := "AS,KH,2D,JC,10C"
-		deck, _ := utils.NewDeck(db, false, cardsParam)
+		deck, _ := utils.NewDeck(deckStore, false, cardsParam)
 	
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -217,11 +271,11 @@ func TestOpenDeck(t *testing.T) {
 
 
 func TestDrawCard(t *testing.T) {
-	db := setupDB()
-	dc := controllers.NewDeckController(db)
+	deckStore := store.NewGormDeckStore(setupDB())
+	dc := controllers.NewDeckController(deckStore)
 
 	t.Run("draw_one_from_full_deck", func(t *testing.T) {
-		deck, _ := utils.NewDeck(db, false, "")
+		deck, _ := utils.NewDeck(deckStore, false, "")
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -238,7 +292,7 @@ func TestDrawCard(t *testing.T) {
 	})
 
 	t.Run("draw_multiple_from_full_deck", func(t *testing.T) {
-		deck, _ := utils.NewDeck(db, false, "")
+		deck, _ := utils.NewDeck(deckStore, false, "")
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -255,7 +309,7 @@ func TestDrawCard(t *testing.T) {
 	})
 
 	t.Run("draw_more_than_remaining", func(t *testing.T) {
-		deck, _ := utils.NewDeck(db, false, "")
+		deck, _ := utils.NewDeck(deckStore, false, "")
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -269,7 +323,7 @@ func TestDrawCard(t *testing.T) {
 
 	t.Run("draw_from_partial_deck", func(t *testing.T) {
 		cardsParam := "AS,KH,2D,JC,10C"
-		deck, _ := utils.NewDeck(db, false, cardsParam)
+		deck, _ := utils.NewDeck(deckStore, false, cardsParam)
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -286,7 +340,7 @@ func TestDrawCard(t *testing.T) {
 	})
 
 	t.Run("invalid_count_parameter", func(t *testing.T) {
-		deck, _ := utils.NewDeck(db, false, "")
+		deck, _ := utils.NewDeck(deckStore, false, "")
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -298,3 +352,204 @@ func TestDrawCard(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 }
+
+func TestDeckHistory(t *testing.T) {
+	deckStore := store.NewGormDeckStore(setupDB())
+	dc := controllers.NewDeckController(deckStore)
+
+	t.Run("history_records_create_and_draw", func(t *testing.T) {
+		deck, _ := utils.NewDeck(deckStore, true, "")
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/deck/"+deck.DeckID+"/draw?count=2", nil)
+		c.Params = []gin.Param{{Key: "deck_id", Value: deck.DeckID}}
+		dc.DrawCard(c)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		c, _ = gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/deck/"+deck.DeckID+"/history", nil)
+		c.Params = []gin.Param{{Key: "deck_id", Value: deck.DeckID}}
+		dc.History(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response controllers.HistoryResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.Equal(t, deck.DeckID, response.DeckID)
+		assert.Len(t, response.History, 2)
+		assert.Equal(t, "create", response.History[0].Method)
+		assert.NotEmpty(t, response.History[0].SeedHash)
+		assert.Equal(t, "draw", response.History[1].Method)
+		assert.Equal(t, 2, response.History[1].Count)
+		assert.Empty(t, response.History[1].SeedHash)
+		assert.NotEqual(t, response.History[0].Fingerprint, response.History[1].Fingerprint)
+	})
+
+	t.Run("history_of_non_existent_deck", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/deck/nonexistentdeck123/history", nil)
+		c.Params = []gin.Param{{Key: "deck_id", Value: "nonexistentdeck123"}}
+
+		dc.History(c)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestOpenDeckAt(t *testing.T) {
+	deckStore := store.NewGormDeckStore(setupDB())
+	dc := controllers.NewDeckController(deckStore)
+
+	deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D,JC")
+	deckStore.Draw(deck.DeckID, 1)
+	utils.ShuffleDeck(deckStore, deck.DeckID, utils.ShuffleOptions{})
+	deckStore.Draw(deck.DeckID, 2)
+
+	openAt := func(at string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/deck/"+deck.DeckID+"?at="+at, nil)
+		c.Params = []gin.Param{{Key: "deck_id", Value: deck.DeckID}}
+		dc.OpenDeck(c)
+		return w
+	}
+
+	t.Run("at_event_number", func(t *testing.T) {
+		w := openAt("2")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response controllers.DeckResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, 3, response.Remaining)
+		assert.False(t, response.Shuffled)
+		assert.Equal(t, []string{"KH", "2D", "JC"}, []string{response.Cards[0].Code, response.Cards[1].Code, response.Cards[2].Code})
+
+		w = openAt("4")
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, 1, response.Remaining)
+		assert.True(t, response.Shuffled)
+	})
+
+	t.Run("at_timestamp", func(t *testing.T) {
+		history, _ := deckStore.History(deck.DeckID)
+
+		w := openAt(history[0].CreatedAt.Format(time.RFC3339Nano))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response controllers.DeckResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, 4, response.Remaining)
+
+		w = openAt(history[0].CreatedAt.Add(-time.Hour).Format(time.RFC3339))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("invalid_at", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, openAt("5").Code)
+		assert.Equal(t, http.StatusBadRequest, openAt("yesterday").Code)
+	})
+}
+
+func TestIfMatch(t *testing.T) {
+	deckStore := store.NewGormDeckStore(setupDB())
+	dc := controllers.NewDeckController(deckStore)
+
+	deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D,JC")
+
+	request := func(handler gin.HandlerFunc, method, path, ifMatch string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(method, path, nil)
+		if ifMatch != "" {
+			c.Request.Header.Set("If-Match", ifMatch)
+		}
+		c.Params = []gin.Param{{Key: "deck_id", Value: deck.DeckID}}
+		handler(c)
+		return w
+	}
+
+	w := request(dc.OpenDeck, "GET", "/deck/"+deck.DeckID, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, `"1"`, etag)
+
+	t.Run("draw_with_current_etag", func(t *testing.T) {
+		w := request(dc.DrawCard, "GET", "/deck/"+deck.DeckID+"/draw?count=1", etag)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+	})
+
+	t.Run("draw_with_stale_etag", func(t *testing.T) {
+		w := request(dc.DrawCard, "GET", "/deck/"+deck.DeckID+"/draw?count=1", etag)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+		assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+		stored, _ := deckStore.Get(deck.DeckID)
+		assert.Equal(t, 3, stored.Remaining)
+	})
+
+	t.Run("shuffle_with_stale_etag", func(t *testing.T) {
+		w := request(dc.ShuffleDeck, "POST", "/deck/"+deck.DeckID+"/shuffle", etag)
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+		w = request(dc.ShuffleDeck, "POST", "/deck/"+deck.DeckID+"/shuffle", `"1", "2"`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+	})
+
+	t.Run("any_version", func(t *testing.T) {
+		w := request(dc.DrawCard, "GET", "/deck/"+deck.DeckID+"/draw?count=1", "*")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestShuffleDeckEndpoint(t *testing.T) {
+	deckStore := store.NewGormDeckStore(setupDB())
+	dc := controllers.NewDeckController(deckStore)
+
+	t.Run("shuffle_top_cards", func(t *testing.T) {
+		deck, _ := utils.NewDeck(deckStore, false, "")
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/deck/"+deck.DeckID+"/shuffle?top=5", nil)
+		c.Params = []gin.Param{{Key: "deck_id", Value: deck.DeckID}}
+
+		dc.ShuffleDeck(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response controllers.DeckResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+
+		assert.True(t, response.Shuffled)
+		assert.Len(t, response.Cards, 52)
+		assert.Equal(t, deck.Cards[5].Code, response.Cards[5].Code)
+	})
+
+	t.Run("shuffle_with_top_and_bottom", func(t *testing.T) {
+		deck, _ := utils.NewDeck(deckStore, false, "")
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/deck/"+deck.DeckID+"/shuffle?top=5&bottom=5", nil)
+		c.Params = []gin.Param{{Key: "deck_id", Value: deck.DeckID}}
+
+		dc.ShuffleDeck(c)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("shuffle_non_existent_deck", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/deck/nonexistentdeck123/shuffle", nil)
+		c.Params = []gin.Param{{Key: "deck_id", Value: "nonexistentdeck123"}}
+
+		dc.ShuffleDeck(c)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
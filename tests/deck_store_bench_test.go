@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func setupBenchmarkDB(b *testing.B) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(b.TempDir(), "bench.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		b.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Deck{}, &models.Card{}, &models.DeckOperation{}, &models.DeckSnapshot{}); err != nil {
+		b.Fatalf("failed to migrate models: %v", err)
+	}
+	return db
+}
+
+// BenchmarkNewDeck_RowByRow creates decks the way NewDeck used to: one
+// INSERT per card outside a transaction, then a query for the cards.
+func BenchmarkNewDeck_RowByRow(b *testing.B) {
+	db := setupBenchmarkDB(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		deck := models.Deck{Remaining: 52}
+		if err := db.Create(&deck).Error; err != nil {
+			b.Fatal(err)
+		}
+		for position, card := range utils.CreateFullDeck() {
+			card.DeckID = deck.DeckID
+			card.Position = position
+			if err := db.Create(&card).Error; err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := db.Where("deck_id = ?", deck.DeckID).Order("position ASC").Find(&deck.Cards).Error; err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// The benchmarks below compare the row-per-card layout with the compact one
+// for creating a deck, drawing a card and reading a deck.
+
+func BenchmarkNewDeck_Batched(b *testing.B) {
+	deckStore := store.NewGormDeckStore(setupBenchmarkDB(b))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := utils.NewDeck(deckStore, false, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewDeck_Compact(b *testing.B) {
+	deckStore := store.NewGormDeckStore(setupBenchmarkDB(b), store.WithCompactStorage())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := utils.NewDeck(deckStore, false, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDraw_Rows(b *testing.B) {
+	benchmarkDraw(b, store.NewGormDeckStore(setupBenchmarkDB(b)))
+}
+
+func BenchmarkDraw_Compact(b *testing.B) {
+	benchmarkDraw(b, store.NewGormDeckStore(setupBenchmarkDB(b), store.WithCompactStorage()))
+}
+
+// benchmarkDraw draws one card at a time, starting a new deck whenever the
+// previous one runs out.
+func benchmarkDraw(b *testing.B, deckStore store.DeckStore) {
+	deck, _ := utils.NewDeck(deckStore, true, "")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i > 0 && i%52 == 0 {
+			b.StopTimer()
+			deck, _ = utils.NewDeck(deckStore, true, "")
+			b.StartTimer()
+		}
+		if _, err := deckStore.Draw(deck.DeckID, 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGet_Rows(b *testing.B) {
+	benchmarkGet(b, store.NewGormDeckStore(setupBenchmarkDB(b)))
+}
+
+func BenchmarkGet_Compact(b *testing.B) {
+	benchmarkGet(b, store.NewGormDeckStore(setupBenchmarkDB(b), store.WithCompactStorage()))
+}
+
+func benchmarkGet(b *testing.B, deckStore store.DeckStore) {
+	deck, _ := utils.NewDeck(deckStore, true, "")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := deckStore.Get(deck.DeckID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+)
+
+func deckStores() map[string]store.DeckStore {
+	return map[string]store.DeckStore{
+		"gorm":              store.NewGormDeckStore(setupDB()),
+		"compact":           store.NewGormDeckStore(setupDB(), store.WithCompactStorage()),
+		"encrypted":         store.NewGormDeckStore(setupDB(), store.WithEncryption(testKeyring("k1"))),
+		"encrypted_compact": store.NewGormDeckStore(setupDB(), store.WithCompactStorage(), store.WithEncryption(testKeyring("k1"))),
+		"memory":            store.NewMemoryDeckStore(),
+		"actors":            store.NewActorDeckStore(store.NewGormDeckStore(setupDB()), 64, time.Minute),
+		"cached":            store.NewCachedDeckStore(store.NewGormDeckStore(setupDB()), 16),
+	}
+}
+
+func TestDeckStore(t *testing.T) {
+	for name, deckStore := range deckStores() {
+		t.Run(name, func(t *testing.T) {
+			testDeckStore(t, deckStore)
+		})
+	}
+}
+
+func testDeckStore(t *testing.T, deckStore store.DeckStore) {
+	deck, err := utils.NewDeck(deckStore, false, "AS,KH,2D,JC,10C")
+	if err != nil {
+		t.Fatalf("failed to create deck: %v", err)
+	}
+
+	t.Run("get", func(t *testing.T) {
+		stored, err := deckStore.Get(deck.DeckID)
+		if err != nil {
+			t.Fatalf("failed to get deck: %v", err)
+		}
+		assertCodes(t, stored.Cards, "AS", "KH", "2D", "JC", "10C")
+		if stored.Remaining != 5 {
+			t.Errorf("expected 5 cards remaining, got %d", stored.Remaining)
+		}
+	})
+
+	t.Run("draw", func(t *testing.T) {
+		drawn, err := deckStore.Draw(deck.DeckID, 2)
+		if err != nil {
+			t.Fatalf("failed to draw: %v", err)
+		}
+		assertCodes(t, drawn, "AS", "KH")
+
+		stored, _ := deckStore.Get(deck.DeckID)
+		assertCodes(t, stored.Cards, "2D", "JC", "10C")
+		if stored.Remaining != 3 {
+			t.Errorf("expected 3 cards remaining, got %d", stored.Remaining)
+		}
+
+		var notEnoughCards *store.NotEnoughCardsError
+		if _, err := deckStore.Draw(deck.DeckID, 4); !errors.As(err, &notEnoughCards) || notEnoughCards.Remaining != 3 {
+			t.Errorf("expected a NotEnoughCardsError with 3 remaining, got %v", err)
+		}
+	})
+
+	t.Run("update_reorders_and_returns_cards", func(t *testing.T) {
+		cards, _ := utils.CreateStackedDeck([]string{"10C", "AS", "2D", "JC"})
+		updated, err := deckStore.Update(deck.DeckID, func(d *models.Deck) (models.DeckOperation, error) {
+			d.Cards = cards
+			return models.NewDeckOperation("test", 0, nil, d.Cards), nil
+		})
+		if err != nil {
+			t.Fatalf("failed to update deck: %v", err)
+		}
+		assertCodes(t, updated.Cards, "10C", "AS", "2D", "JC")
+
+		stored, _ := deckStore.Get(deck.DeckID)
+		assertCodes(t, stored.Cards, "10C", "AS", "2D", "JC")
+		if stored.Remaining != 4 {
+			t.Errorf("expected 4 cards remaining, got %d", stored.Remaining)
+		}
+	})
+
+	t.Run("update_rejects_foreign_cards", func(t *testing.T) {
+		cards, _ := utils.CreateStackedDeck([]string{"QS"})
+		_, err := deckStore.Update(deck.DeckID, func(d *models.Deck) (models.DeckOperation, error) {
+			d.Cards = cards
+			return models.NewDeckOperation("test", 0, nil, d.Cards), nil
+		})
+		if err == nil {
+			t.Errorf("expected an error for a card that is not part of the deck")
+		}
+	})
+
+	t.Run("history", func(t *testing.T) {
+		history, err := deckStore.History(deck.DeckID)
+		if err != nil {
+			t.Fatalf("failed to load history: %v", err)
+		}
+		methods := []string{}
+		for i, op := range history {
+			methods = append(methods, op.Method)
+			if op.Seq != i+1 {
+				t.Errorf("expected event %d to have number %d, got %d", i, i+1, op.Seq)
+			}
+		}
+		expected := []string{models.MethodCreate, models.MethodDraw, "test"}
+		if len(methods) != len(expected) {
+			t.Fatalf("expected history %v, got %v", expected, methods)
+		}
+		for i := range expected {
+			if methods[i] != expected[i] {
+				t.Errorf("expected history %v, got %v", expected, methods)
+			}
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		history, err := deckStore.History(deck.DeckID)
+		if err != nil {
+			t.Fatalf("failed to load history: %v", err)
+		}
+		current, err := deckStore.Get(deck.DeckID)
+		if err != nil {
+			t.Fatalf("failed to get deck: %v", err)
+		}
+
+		replayed, err := utils.ReplayDeck(deck.DeckID, history)
+		if err != nil {
+			t.Fatalf("failed to replay deck: %v", err)
+		}
+		assertCodes(t, replayed.Cards, models.CardCodes(current.Cards)...)
+
+		created, err := utils.ReplayDeck(deck.DeckID, history[:1])
+		if err != nil {
+			t.Fatalf("failed to replay the create event: %v", err)
+		}
+		assertCodes(t, created.Cards, "AS", "KH", "2D", "JC", "10C")
+	})
+
+	t.Run("list", func(t *testing.T) {
+		decks, err := deckStore.List(0, 10)
+		if err != nil {
+			t.Fatalf("failed to list decks: %v", err)
+		}
+		if len(decks) != 1 || decks[0].DeckID != deck.DeckID {
+			t.Errorf("expected to list deck %s, got %v", deck.DeckID, decks)
+		}
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		if _, err := deckStore.Get("nonexistentdeck123"); !errors.Is(err, store.ErrDeckNotFound) {
+			t.Errorf("expected ErrDeckNotFound from Get, got %v", err)
+		}
+		if _, err := deckStore.Draw("nonexistentdeck123", 1); !errors.Is(err, store.ErrDeckNotFound) {
+			t.Errorf("expected ErrDeckNotFound from Draw, got %v", err)
+		}
+		if _, err := deckStore.History("nonexistentdeck123"); !errors.Is(err, store.ErrDeckNotFound) {
+			t.Errorf("expected ErrDeckNotFound from History, got %v", err)
+		}
+	})
+}
+
+func TestDeckStore_Versions(t *testing.T) {
+	for name, deckStore := range deckStores() {
+		t.Run(name, func(t *testing.T) {
+			deck, err := utils.NewDeck(deckStore, false, "AS,KH,2D")
+			if err != nil {
+				t.Fatalf("failed to create deck: %v", err)
+			}
+			if deck.Version != 1 {
+				t.Errorf("expected a new deck at version 1, got %d", deck.Version)
+			}
+
+			var drawn []models.Card
+			updated, err := deckStore.Update(deck.DeckID, store.IfVersion([]int{1}, store.DrawFunc(1, &drawn)))
+			if err != nil {
+				t.Fatalf("failed to draw at the current version: %v", err)
+			}
+			if updated.Version != 2 {
+				t.Errorf("expected version 2 after a draw, got %d", updated.Version)
+			}
+
+			_, err = deckStore.Update(deck.DeckID, store.IfVersion([]int{1}, store.DrawFunc(1, &drawn)))
+			var mismatch *store.VersionMismatchError
+			if !errors.As(err, &mismatch) || mismatch.Version != 2 {
+				t.Fatalf("expected a version mismatch at version 2, got %v", err)
+			}
+
+			stored, _ := deckStore.Get(deck.DeckID)
+			if stored.Version != 2 || stored.Remaining != 2 {
+				t.Errorf("a failed conditional draw changed the deck: version %d, %d remaining", stored.Version, stored.Remaining)
+			}
+		})
+	}
+}
+
+func TestDeckStore_ListPages(t *testing.T) {
+	for name, deckStore := range deckStores() {
+		t.Run(name, func(t *testing.T) {
+			var created []string
+			for i := 0; i < 5; i++ {
+				deck, err := utils.NewDeck(deckStore, false, "AS,KH")
+				if err != nil {
+					t.Fatalf("failed to create deck: %v", err)
+				}
+				created = append(created, deck.DeckID)
+				time.Sleep(time.Millisecond)
+			}
+
+			var listed []string
+			for offset := 0; offset < 6; offset += 2 {
+				decks, err := deckStore.List(offset, 2)
+				if err != nil {
+					t.Fatalf("failed to list decks at offset %d: %v", offset, err)
+				}
+				for _, deck := range decks {
+					if len(deck.Cards) != 0 {
+						t.Errorf("expected deck %s to be listed without its cards", deck.DeckID)
+					}
+					listed = append(listed, deck.DeckID)
+				}
+			}
+
+			if len(listed) != len(created) {
+				t.Fatalf("expected %d decks over three pages, got %v", len(created), listed)
+			}
+			for i := range created {
+				if listed[i] != created[i] {
+					t.Errorf("expected deck %s at position %d, got %s", created[i], i, listed[i])
+				}
+			}
+		})
+	}
+}
+
+func TestGormDeckStore_MixedLayouts(t *testing.T) {
+	db := setupDB()
+	rowStore := store.NewGormDeckStore(db)
+	compactStore := store.NewGormDeckStore(db, store.WithCompactStorage())
+
+	rowDeck, _ := utils.NewDeck(rowStore, false, "AS,KH,2D")
+	compactDeck, _ := utils.NewDeck(compactStore, false, "AS,KH,2D")
+
+	var cardRows int64
+	db.Model(&models.Card{}).Where("deck_id = ?", compactDeck.DeckID).Count(&cardRows)
+	if cardRows != 0 {
+		t.Errorf("expected no card rows for a compact deck, got %d", cardRows)
+	}
+
+	// Each store keeps using the layout a deck was created with.
+	drawn, err := compactStore.Draw(rowDeck.DeckID, 1)
+	if err != nil {
+		t.Fatalf("failed to draw from a row deck: %v", err)
+	}
+	assertCodes(t, drawn, "AS")
+	drawn, err = rowStore.Draw(compactDeck.DeckID, 2)
+	if err != nil {
+		t.Fatalf("failed to draw from a compact deck: %v", err)
+	}
+	assertCodes(t, drawn, "AS", "KH")
+
+	stored, _ := rowStore.Get(rowDeck.DeckID)
+	assertCodes(t, stored.Cards, "KH", "2D")
+	stored, _ = compactStore.Get(compactDeck.DeckID)
+	assertCodes(t, stored.Cards, "2D")
+}
+
+func assertCodes(t *testing.T, cards []models.Card, codes ...string) {
+	t.Helper()
+
+	if len(cards) != len(codes) {
+		t.Fatalf("expected %d cards, got %d", len(codes), len(cards))
+	}
+	for i, card := range cards {
+		if card.Code != codes[i] {
+			t.Errorf("card at position %d should have code %s, but has %s", i, codes[i], card.Code)
+		}
+	}
+}
@@ -1,12 +1,18 @@
 package tests
 
 import (
+	"bytes"
+	"errors"
 	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/store"
 	"github.com/lando-ke/card-api/utils"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"testing"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
+	"testing"
 )
 
 func setupDatabase(t *testing.T) *gorm.DB {
@@ -17,7 +23,7 @@ func setupDatabase(t *testing.T) *gorm.DB {
 	}
 
 	// Migrate the models
-	err = db.AutoMigrate(&models.Deck{}, &models.Card{})
+	err = db.AutoMigrate(&models.Deck{}, &models.Card{}, &models.DeckOperation{}, &models.DeckSnapshot{})
 	if err != nil {
 		t.Fatalf("failed to migrate models: %v", err)
 	}
@@ -27,15 +33,16 @@ func setupDatabase(t *testing.T) *gorm.DB {
 
 func TestNewDeck_Shuffled(t *testing.T) {
 	db := setupDatabase(t)
+	deckStore := store.NewGormDeckStore(db)
 
 	// Create a shuffled deck
-	shuffledDeck, err := utils.NewDeck(db, true, "")
+	shuffledDeck, err := utils.NewDeck(deckStore, true, "")
 	if err != nil {
 		t.Fatalf("failed to create shuffled deck: %v", err)
 	}
 
 	// Create an unshuffled deck
-	unshuffledDeck, err := utils.NewDeck(db, false, "")
+	unshuffledDeck, err := utils.NewDeck(deckStore, false, "")
 	if err != nil {
 		t.Fatalf("failed to create unshuffled deck: %v", err)
 	}
@@ -55,9 +62,10 @@ func TestNewDeck_Shuffled(t *testing.T) {
 
 func TestNewDeck_Unshuffled(t *testing.T) {
 	db := setupDatabase(t)
+	deckStore := store.NewGormDeckStore(db)
 
 	// Create an unshuffled deck
-	unshuffledDeck, err := utils.NewDeck(db, false, "")
+	unshuffledDeck, err := utils.NewDeck(deckStore, false, "")
 	if err != nil {
 		t.Fatalf("failed to create unshuffled deck: %v", err)
 	}
@@ -118,3 +126,235 @@ func TestValidateCardsParam(t *testing.T) {
 	}
 }
 
+
+// testSeed returns a shuffle seed of b repeated.
+func testSeed(b byte) []byte {
+	return bytes.Repeat([]byte{b}, utils.ShuffleSeedSize)
+}
+
+func TestShuffleCards_SameSeedSameOrder(t *testing.T) {
+	first, _ := utils.ShuffleCards(utils.CreateFullDeck(), testSeed(42), utils.ShuffleOptions{})
+	second, _ := utils.ShuffleCards(utils.CreateFullDeck(), testSeed(42), utils.ShuffleOptions{})
+
+	for i := range first {
+		if first[i].Code != second[i].Code {
+			t.Fatalf("shuffles with the same seed differ at position %d: %s != %s", i, first[i].Code, second[i].Code)
+		}
+	}
+
+	other, _ := utils.ShuffleCards(utils.CreateFullDeck(), testSeed(43), utils.ShuffleOptions{})
+	if reflect.DeepEqual(models.CardCodes(first), models.CardCodes(other)) {
+		t.Errorf("shuffles with different seeds gave the same order")
+	}
+
+	// Seeds shorter than 32 bytes would leave most orders out of reach.
+	if _, err := utils.ShuffleCards(utils.CreateFullDeck(), make([]byte, 8), utils.ShuffleOptions{}); !errors.Is(err, utils.ErrInvalidShuffle) {
+		t.Errorf("expected ErrInvalidShuffle for a short seed, got %v", err)
+	}
+}
+
+func TestShuffleCards_Uniform(t *testing.T) {
+	// Every order of three cards comes out about as often as the others.
+	counts := map[string]int{}
+	for i := 0; i < 6000; i++ {
+		cards, _ := utils.ShuffleCards(utils.CreateFullDeck()[:3], utils.NewShuffleSeed(), utils.ShuffleOptions{})
+		counts[strings.Join(models.CardCodes(cards), ",")]++
+	}
+	if len(counts) != 6 {
+		t.Fatalf("expected all 6 orders, got %v", counts)
+	}
+	for order, n := range counts {
+		if n < 850 || n > 1150 {
+			t.Errorf("order %s came out %d times in 6000 shuffles", order, n)
+		}
+	}
+}
+
+func TestCreateStackedDeck(t *testing.T) {
+	cards, invalidCards := utils.CreateStackedDeck([]string{"10h", "AS", "2C"})
+	if len(invalidCards) != 0 {
+		t.Fatalf("expected no invalid cards, got %v", invalidCards)
+	}
+
+	expectedCards := []string{"10H", "AS", "2C"}
+	for i, card := range cards {
+		if card.Code != expectedCards[i] {
+			t.Errorf("card at position %d should have code %s, but has %s", i, expectedCards[i], card.Code)
+		}
+	}
+
+	cards, invalidCards = utils.CreateStackedDeck([]string{"AS", "1X", "AS"})
+	if cards != nil {
+		t.Errorf("expected no cards for an invalid stack, got %d", len(cards))
+	}
+	expectedInvalidCards := []string{"1X", "AS (duplicate)"}
+	if !reflect.DeepEqual(invalidCards, expectedInvalidCards) {
+		t.Errorf("invalid cards should be %v, but got %v", expectedInvalidCards, invalidCards)
+	}
+}
+
+func TestShuffleCards_Partial(t *testing.T) {
+	reference := utils.CreateFullDeck()
+
+	t.Run("top", func(t *testing.T) {
+		cards, err := utils.ShuffleCards(utils.CreateFullDeck(), testSeed(7), utils.ShuffleOptions{Top: 10})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for i := 10; i < len(cards); i++ {
+			if cards[i].Code != reference[i].Code {
+				t.Errorf("card at position %d moved although only the top 10 were shuffled", i)
+			}
+		}
+	})
+
+	t.Run("bottom", func(t *testing.T) {
+		cards, err := utils.ShuffleCards(utils.CreateFullDeck(), testSeed(7), utils.ShuffleOptions{Bottom: 26})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for i := 0; i < 26; i++ {
+			if cards[i].Code != reference[i].Code {
+				t.Errorf("card at position %d moved although only the bottom 26 were shuffled", i)
+			}
+		}
+	})
+
+	t.Run("pinned", func(t *testing.T) {
+		cards, err := utils.ShuffleCards(utils.CreateFullDeck(), testSeed(7), utils.ShuffleOptions{Pinned: []string{"2S", "as"}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for i, card := range reference {
+			if (card.Code == "2S" || card.Code == "AS") && cards[i].Code != card.Code {
+				t.Errorf("pinned card %s moved from position %d", card.Code, i)
+			}
+		}
+	})
+
+	t.Run("invalid_options", func(t *testing.T) {
+		invalid := []utils.ShuffleOptions{
+			{Top: 5, Bottom: 5},
+			{Top: 53},
+			{Bottom: -1},
+			{Pinned: []string{"XX"}},
+		}
+		for _, opts := range invalid {
+			if _, err := utils.ShuffleCards(utils.CreateFullDeck(), testSeed(7), opts); !errors.Is(err, utils.ErrInvalidShuffle) {
+				t.Errorf("expected ErrInvalidShuffle for %+v, got %v", opts, err)
+			}
+		}
+	})
+}
+
+func TestShuffleDeck(t *testing.T) {
+	db := setupDatabase(t)
+	deckStore := store.NewGormDeckStore(db)
+
+	deck, err := utils.NewDeck(deckStore, false, "")
+	if err != nil {
+		t.Fatalf("failed to create deck: %v", err)
+	}
+
+	shuffled, err := utils.ShuffleDeck(deckStore, deck.DeckID, utils.ShuffleOptions{Bottom: 40})
+	if err != nil {
+		t.Fatalf("failed to shuffle deck: %v", err)
+	}
+	if !shuffled.Shuffled {
+		t.Errorf("deck should be marked as shuffled")
+	}
+
+	var cards []models.Card
+	db.Where("deck_id = ?", deck.DeckID).Order("id ASC").Find(&cards)
+	for i := 0; i < 12; i++ {
+		if cards[i].Code != deck.Cards[i].Code {
+			t.Errorf("card at position %d moved although only the bottom 40 were shuffled", i)
+		}
+	}
+
+	if _, err := utils.ShuffleDeck(deckStore, "nonexistentdeck123", utils.ShuffleOptions{}); !errors.Is(err, store.ErrDeckNotFound) {
+		t.Errorf("expected ErrDeckNotFound, got %v", err)
+	}
+}
+
+func TestGormDeckStore_ConcurrentDraws(t *testing.T) {
+	// Concurrent transactions need a database shared by every connection in
+	// the pool, which an in-memory database is not.
+	dsn := filepath.Join(t.TempDir(), "draw.db") + "?_busy_timeout=5000&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Deck{}, &models.Card{}, &models.DeckOperation{}, &models.DeckSnapshot{}); err != nil {
+		t.Fatalf("failed to migrate models: %v", err)
+	}
+
+	deckStore := store.NewGormDeckStore(db)
+	deck, err := utils.NewDeck(deckStore, true, "")
+	if err != nil {
+		t.Fatalf("failed to create deck: %v", err)
+	}
+
+	const workers = 16
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	drawn := make(map[string]int)
+	failures := []error{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				cards, err := deckStore.Draw(deck.DeckID, 1)
+				var notEnoughCards *store.NotEnoughCardsError
+				if errors.As(err, &notEnoughCards) {
+					return
+				}
+
+				mu.Lock()
+				if err != nil {
+					failures = append(failures, err)
+					mu.Unlock()
+					return
+				}
+				for _, card := range cards {
+					drawn[card.Code]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range failures {
+		t.Errorf("draw failed: %v", err)
+	}
+
+	if len(drawn) != 52 {
+		t.Errorf("expected 52 distinct cards to be drawn, got %d", len(drawn))
+	}
+	for code, times := range drawn {
+		if times != 1 {
+			t.Errorf("card %s was drawn %d times", code, times)
+		}
+	}
+
+	var stored models.Deck
+	db.Where("deck_id = ?", deck.DeckID).First(&stored)
+	if stored.Remaining != 0 {
+		t.Errorf("expected 0 cards remaining, got %d", stored.Remaining)
+	}
+
+	var liveCards int64
+	db.Model(&models.Card{}).Where("deck_id = ? AND state = ?", deck.DeckID, models.CardInDeck).Count(&liveCards)
+	if liveCards != 0 {
+		t.Errorf("expected no live cards, got %d", liveCards)
+	}
+
+	var draws int64
+	db.Model(&models.DeckOperation{}).Where("deck_id = ? AND method = ?", deck.DeckID, models.MethodDraw).Count(&draws)
+	if draws != 52 {
+		t.Errorf("expected 52 recorded draws, got %d", draws)
+	}
+}
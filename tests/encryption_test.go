@@ -0,0 +1,300 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/lando-ke/card-api/encryption"
+	"github.com/lando-ke/card-api/fsck"
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// testKeyring returns a keyring of keys derived from their IDs, with the
+// first one as the primary key.
+func testKeyring(ids ...string) *encryption.Keyring {
+	pairs := make([]string, len(ids))
+	for i, id := range ids {
+		key := sha256.Sum256([]byte(id))
+		pairs[i] = id + ":" + base64.StdEncoding.EncodeToString(key[:])
+	}
+	keys, err := encryption.ParseKeys(strings.Join(pairs, ","))
+	if err != nil {
+		panic(err)
+	}
+	return keys
+}
+
+func TestKeyring(t *testing.T) {
+	keys := testKeyring("new", "old")
+
+	sealed, err := keys.EncryptString("10H")
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	assert.NotContains(t, sealed, "10H")
+	id, ok := encryption.KeyID([]byte(sealed))
+	assert.True(t, ok)
+	assert.Equal(t, "new", id)
+
+	plain, err := keys.DecryptString(sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "10H", plain)
+
+	// Values of different lengths encrypt to the same length.
+	short, _ := keys.EncryptString("2H")
+	assert.Equal(t, len(sealed), len(short))
+
+	// Data written before encryption was turned on reads as it is.
+	plain, err = keys.DecryptString("AS")
+	assert.NoError(t, err)
+	assert.Equal(t, "AS", plain)
+
+	// Old keys only decrypt.
+	oldSealed, _ := testKeyring("old").EncryptString("AS")
+	plain, err = keys.DecryptString(oldSealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "AS", plain)
+	assert.False(t, keys.Current([]byte(oldSealed)))
+	assert.True(t, keys.Current([]byte(sealed)))
+
+	if _, err := testKeyring("other").DecryptString(sealed); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+	var none *encryption.Keyring
+	if _, err := none.DecryptString(sealed); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey without keys, got %v", err)
+	}
+
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if _, err := keys.DecryptString(tampered); err == nil {
+		t.Errorf("expected tampered data to fail to decrypt")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := encryption.ParseKeys("")
+	assert.NoError(t, err)
+	assert.Nil(t, keys)
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	for name, spec := range map[string]string{
+		"no_id":      key,
+		"short_key":  "k1:" + base64.StdEncoding.EncodeToString(make([]byte, 16)),
+		"not_base64": "k1:not base64",
+		"bad_id":     "k:1:" + key,
+		"twice":      "k1:" + key + ",k1:" + key,
+		"reversed":   key + ":k1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := encryption.ParseKeys(spec)
+			if !errors.Is(err, encryption.ErrInvalidKeys) {
+				t.Fatalf("expected ErrInvalidKeys, got %v", err)
+			}
+			assert.NotContains(t, err.Error(), key[:8], "the error gives the key away")
+		})
+	}
+}
+
+func TestGormDeckStore_Encryption(t *testing.T) {
+	db := setupDB()
+	keys := testKeyring("k1")
+	rowStore := store.NewGormDeckStore(db, store.WithEncryption(keys))
+	compactStore := store.NewGormDeckStore(db, store.WithCompactStorage(), store.WithEncryption(keys))
+
+	rows, _ := utils.NewDeck(rowStore, true, "")
+	compact, _ := utils.NewDeck(compactStore, true, "")
+	for _, deckID := range []string{rows.DeckID, compact.DeckID} {
+		rowStore.Draw(deckID, 2)
+		utils.SaveSnapshot(rowStore, deckID, "checkpoint")
+	}
+
+	assertNothingReadable(t, db)
+
+	// The API sees the plain cards.
+	deck, err := rowStore.Get(rows.DeckID)
+	if err != nil {
+		t.Fatalf("failed to get deck: %v", err)
+	}
+	assert.Len(t, deck.Cards, 50)
+	assert.Contains(t, models.CardValues, deck.Cards[0].Value)
+	assert.Contains(t, models.CardSuits, deck.Cards[0].Suit)
+
+	doc, err := utils.ExportDeck(compactStore, compact.DeckID)
+	if err != nil {
+		t.Fatalf("failed to export deck: %v", err)
+	}
+	assert.NotEmpty(t, doc.ShuffleSeed)
+	assert.Len(t, doc.Drawn, 2)
+
+	// Without the key nothing can be read.
+	if _, err := store.NewGormDeckStore(db).Get(compact.DeckID); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey, got %v", err)
+	}
+
+	report, err := fsck.NewChecker(db, fsck.WithKeyring(keys)).Check()
+	assert.NoError(t, err)
+	assert.Empty(t, report.Problems)
+}
+
+func TestGormDeckStore_Rekey(t *testing.T) {
+	db := setupDB()
+
+	// Decks written without encryption and under an old key.
+	plain, _ := utils.NewDeck(store.NewGormDeckStore(db), true, "")
+	oldStore := store.NewGormDeckStore(db, store.WithCompactStorage(), store.WithEncryption(testKeyring("old")))
+	old, _ := utils.NewDeck(oldStore, true, "")
+	oldStore.Draw(old.DeckID, 3)
+	utils.SaveSnapshot(oldStore, old.DeckID, "checkpoint")
+
+	rotated := store.NewGormDeckStore(db, store.WithEncryption(testKeyring("new", "old")))
+	before, _ := rotated.Get(old.DeckID)
+
+	rekeyed, err := rotated.Rekey(1)
+	if err != nil {
+		t.Fatalf("failed to rekey: %v", err)
+	}
+	assert.Equal(t, 2, rekeyed)
+	assertNothingReadable(t, db)
+
+	// Once rekeyed, the old key is no longer needed.
+	newOnly := store.NewGormDeckStore(db, store.WithEncryption(testKeyring("new")))
+	after, err := newOnly.Get(old.DeckID)
+	if err != nil {
+		t.Fatalf("failed to get rekeyed deck: %v", err)
+	}
+	assert.Equal(t, models.CardCodes(before.Cards), models.CardCodes(after.Cards))
+	if _, err := newOnly.Get(plain.DeckID); err != nil {
+		t.Errorf("failed to get rekeyed plain deck: %v", err)
+	}
+	history, _ := newOnly.History(old.DeckID)
+	if _, err := utils.ReplayDeck(old.DeckID, history); err != nil {
+		t.Errorf("failed to replay rekeyed deck: %v", err)
+	}
+	if _, err := newOnly.Snapshot(old.DeckID, "checkpoint"); err != nil {
+		t.Errorf("failed to read rekeyed snapshot: %v", err)
+	}
+
+	rekeyed, _ = newOnly.Rekey(10)
+	assert.Equal(t, 0, rekeyed)
+
+	if _, err := store.NewGormDeckStore(db).Rekey(10); !errors.Is(err, store.ErrNoEncryption) {
+		t.Errorf("expected ErrNoEncryption, got %v", err)
+	}
+}
+
+// assertNothingReadable checks that no card, card order, seed, fingerprint
+// or snapshot is stored in the clear.
+func assertNothingReadable(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	encrypted := func(what, value string) {
+		if value == "" {
+			return
+		}
+		if _, ok := encryption.KeyID([]byte(value)); !ok {
+			t.Errorf("%s is stored in the clear: %q", what, value)
+		}
+	}
+
+	var cards []models.Card
+	db.Unscoped().Find(&cards)
+	for _, card := range cards {
+		encrypted("card value", card.Value)
+		encrypted("card suit", card.Suit)
+		encrypted("card code", card.Code)
+	}
+
+	var decks []models.Deck
+	db.Find(&decks)
+	for _, deck := range decks {
+		encrypted("card order", string(deck.CardOrder))
+	}
+
+	var operations []models.DeckOperation
+	db.Find(&operations)
+	for _, op := range operations {
+		encrypted("operation cards", op.Cards)
+		encrypted("operation seed", op.SealedSeed)
+		encrypted("operation fingerprint", op.Fingerprint)
+		if op.Seed != nil || op.ShuffleSeed != "" {
+			t.Errorf("seed is stored in the clear")
+		}
+	}
+
+	var snapshots []models.DeckSnapshot
+	db.Find(&snapshots)
+	for _, snapshot := range snapshots {
+		encrypted("snapshot cards", snapshot.Cards)
+		encrypted("snapshot drawn cards", snapshot.Drawn)
+		encrypted("snapshot fingerprint", snapshot.Fingerprint)
+	}
+}
+
+func TestGormDeckStore_EncryptedRowOrder(t *testing.T) {
+	db := setupDB()
+	keys := testKeyring("k1")
+	deckStore := store.NewGormDeckStore(db, store.WithEncryption(keys))
+
+	// Rows are inserted in the order the deck is created, so without care
+	// their IDs would tell the cards of an unshuffled deck apart.
+	deck, _ := utils.NewDeck(deckStore, false, "")
+	shuffled, err := deckStore.Update(deck.DeckID, utils.ShuffleFunc(utils.ShuffleOptions{}))
+	if err != nil {
+		t.Fatalf("failed to shuffle: %v", err)
+	}
+	deckStore.Draw(deck.DeckID, 2)
+
+	assertRowsInIDOrder(t, db, deck.DeckID)
+	assertNothingReadable(t, db)
+
+	stored, _ := deckStore.Get(deck.DeckID)
+	assert.Equal(t, models.CardCodes(shuffled.Cards)[2:], models.CardCodes(stored.Cards))
+
+	// Undo puts the drawn cards back on top.
+	if _, err := utils.UndoDeck(deckStore, deck.DeckID, 1, 10); err != nil {
+		t.Fatalf("failed to undo: %v", err)
+	}
+	assertRowsInIDOrder(t, db, deck.DeckID)
+	stored, _ = deckStore.Get(deck.DeckID)
+	assert.Equal(t, models.CardCodes(shuffled.Cards), models.CardCodes(stored.Cards))
+}
+
+func TestGormDeckStore_RekeyRowOrder(t *testing.T) {
+	db := setupDB()
+
+	// A deck shuffled in the clear keeps its rows in the order it was
+	// created in.
+	plain := store.NewGormDeckStore(db)
+	deck, _ := utils.NewDeck(plain, false, "")
+	shuffled, _ := plain.Update(deck.DeckID, utils.ShuffleFunc(utils.ShuffleOptions{}))
+
+	encrypted := store.NewGormDeckStore(db, store.WithEncryption(testKeyring("k1")))
+	if _, err := encrypted.Rekey(10); err != nil {
+		t.Fatalf("failed to rekey: %v", err)
+	}
+
+	assertRowsInIDOrder(t, db, deck.DeckID)
+	stored, _ := encrypted.Get(deck.DeckID)
+	assert.Equal(t, models.CardCodes(shuffled.Cards), models.CardCodes(stored.Cards))
+}
+
+// assertRowsInIDOrder checks that the raw card rows of a deck, read in draw
+// order, have ascending IDs, so that their IDs tell nothing of the order.
+func assertRowsInIDOrder(t *testing.T, db *gorm.DB, deckID string) {
+	t.Helper()
+
+	var ids []uint
+	db.Model(&models.Card{}).Where("deck_id = ? AND state = ?", deckID, models.CardInDeck).Order("position ASC").Pluck("id", &ids)
+	for i := 1; i < len(ids); i++ {
+		if ids[i] <= ids[i-1] {
+			t.Fatalf("row %d at position %d follows row %d, giving the order away", ids[i], i, ids[i-1])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lando-ke/card-api/controllers"
+	"github.com/lando-ke/card-api/expiry"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeckStore_DeleteExpired(t *testing.T) {
+	for name, deckStore := range deckStores() {
+		t.Run(name, func(t *testing.T) {
+			expired, _ := utils.CreateDeck(deckStore, utils.CreateFullDeck(), utils.DeckOptions{TTL: time.Millisecond})
+			alive, _ := utils.CreateDeck(deckStore, utils.CreateFullDeck(), utils.DeckOptions{TTL: time.Hour})
+			idle, _ := utils.NewDeck(deckStore, false, "")
+
+			now := time.Now().Add(time.Second)
+
+			deleted, err := deckStore.DeleteExpired(now, 0, 10)
+			if err != nil {
+				t.Fatalf("failed to delete expired decks: %v", err)
+			}
+			if deleted != 1 {
+				t.Errorf("expected 1 deck to be deleted, got %d", deleted)
+			}
+			if _, err := deckStore.Get(expired.DeckID); !errors.Is(err, store.ErrDeckNotFound) {
+				t.Errorf("expected the expired deck to be gone, got %v", err)
+			}
+			if _, err := deckStore.History(expired.DeckID); !errors.Is(err, store.ErrDeckNotFound) {
+				t.Errorf("expected the history of the expired deck to be gone, got %v", err)
+			}
+
+			// With an idle TTL the deck without a TTL of its own goes too,
+			// but not the one whose TTL has not passed.
+			deleted, _ = deckStore.DeleteExpired(now, time.Millisecond, 10)
+			if deleted != 1 {
+				t.Errorf("expected 1 idle deck to be deleted, got %d", deleted)
+			}
+			if _, err := deckStore.Get(idle.DeckID); !errors.Is(err, store.ErrDeckNotFound) {
+				t.Errorf("expected the idle deck to be gone, got %v", err)
+			}
+			if _, err := deckStore.Get(alive.DeckID); err != nil {
+				t.Errorf("expected the deck with a TTL to be kept, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExpiryWorker(t *testing.T) {
+	deckStore := store.NewMemoryDeckStore()
+	for i := 0; i < 5; i++ {
+		utils.CreateDeck(deckStore, utils.CreateFullDeck(), utils.DeckOptions{TTL: time.Nanosecond})
+	}
+	kept, _ := utils.NewDeck(deckStore, false, "")
+
+	worker := expiry.NewWorker(deckStore, time.Hour, 0, 2)
+	deleted, err := worker.Purge(context.Background())
+	if err != nil {
+		t.Fatalf("failed to purge: %v", err)
+	}
+	if deleted != 5 {
+		t.Errorf("expected 5 decks to be deleted in batches, got %d", deleted)
+	}
+	if _, err := deckStore.Get(kept.DeckID); err != nil {
+		t.Errorf("expected the deck without a TTL to be kept, got %v", err)
+	}
+
+	expired, _ := utils.CreateDeck(deckStore, utils.CreateFullDeck(), utils.DeckOptions{TTL: time.Nanosecond})
+	worker = expiry.NewWorker(deckStore, time.Millisecond, 0, 10)
+	worker.Start()
+	assert.Eventually(t, func() bool {
+		_, err := deckStore.Get(expired.DeckID)
+		return errors.Is(err, store.ErrDeckNotFound)
+	}, time.Second, time.Millisecond)
+	worker.Stop()
+}
+
+func TestExpiredDeckEndpoints(t *testing.T) {
+	deckStore := store.NewMemoryDeckStore()
+	dc := controllers.NewDeckController(deckStore)
+
+	expired, _ := utils.CreateDeck(deckStore, utils.CreateFullDeck(), utils.DeckOptions{TTL: time.Nanosecond})
+
+	handlers := map[string]gin.HandlerFunc{
+		"open":    dc.OpenDeck,
+		"draw":    dc.DrawCard,
+		"shuffle": dc.ShuffleDeck,
+		"history": dc.History,
+	}
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/deck/"+expired.DeckID, nil)
+			c.Params = []gin.Param{{Key: "deck_id", Value: expired.DeckID}}
+
+			handler(c)
+
+			assert.Equal(t, http.StatusGone, w.Code)
+		})
+	}
+
+	t.Run("idle_ttl", func(t *testing.T) {
+		deck, _ := utils.NewDeck(deckStore, false, "")
+		idle := controllers.NewDeckController(deckStore, controllers.WithIdleTTL(time.Nanosecond))
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/deck/"+deck.DeckID, nil)
+		c.Params = []gin.Param{{Key: "deck_id", Value: deck.DeckID}}
+
+		idle.OpenDeck(c)
+
+		assert.Equal(t, http.StatusGone, w.Code)
+	})
+
+	t.Run("create_with_ttl", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/deck?ttl=30m", nil)
+
+		dc.CreateDeck(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "expires_at")
+
+		w = httptest.NewRecorder()
+		c, _ = gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/deck?ttl=soon", nil)
+
+		dc.CreateDeck(c)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lando-ke/card-api/controllers"
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/routes"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	for name, source := range deckStores() {
+		t.Run(name, func(t *testing.T) {
+			deck, _ := utils.NewDeck(source, true, "")
+			drawn, _ := source.Draw(deck.DeckID, 5)
+
+			doc, err := utils.ExportDeck(source, deck.DeckID)
+			if err != nil {
+				t.Fatalf("failed to export deck: %v", err)
+			}
+			assert.Equal(t, utils.ExportFormatVersion, doc.FormatVersion)
+			assert.Len(t, doc.Composition, 52)
+			assert.Len(t, doc.Remaining, 47)
+			assert.Len(t, doc.Drawn, 5)
+			assert.Len(t, doc.ShuffleSeed, 2*utils.ShuffleSeedSize)
+
+			// A JSON round trip moves the deck to another environment.
+			data, _ := json.Marshal(doc)
+			var decoded utils.DeckExport
+			json.Unmarshal(data, &decoded)
+
+			target := store.NewGormDeckStore(setupDB())
+			imported, err := utils.ImportDeck(target, decoded, "")
+			if err != nil {
+				t.Fatalf("failed to import deck: %v", err)
+			}
+			assert.Equal(t, deck.DeckID, imported.DeckID)
+			assert.True(t, imported.Shuffled)
+
+			original, _ := source.Get(deck.DeckID)
+			stored, _ := target.Get(deck.DeckID)
+			assertCodes(t, stored.Cards, doc.Remaining...)
+			assert.Equal(t, original.Remaining, stored.Remaining)
+
+			// The drawn cards came along and can be brought back.
+			undone, err := utils.UndoDeck(target, deck.DeckID, 1, 10)
+			if err != nil {
+				t.Fatalf("failed to undo the imported draw: %v", err)
+			}
+			for i, card := range drawn {
+				assert.Equal(t, card.Code, undone.Cards[i].Code)
+			}
+
+			// Importing again cannot reuse the ID.
+			again, err := utils.ImportDeck(target, decoded, "")
+			if err != nil {
+				t.Fatalf("failed to import deck again: %v", err)
+			}
+			assert.NotEqual(t, deck.DeckID, again.DeckID)
+		})
+	}
+}
+
+func TestImportDeck_Validation(t *testing.T) {
+	deckStore := store.NewMemoryDeckStore()
+	valid := utils.DeckExport{
+		Format:        utils.ExportFormat,
+		FormatVersion: 1,
+		Composition:   []string{"AS", "KH", "2D"},
+		Remaining:     []string{"KH", "2D"},
+		Drawn:         []string{"AS"},
+	}
+
+	if _, err := utils.ImportDeck(deckStore, valid, ""); err != nil {
+		t.Fatalf("failed to import a valid document: %v", err)
+	}
+
+	// Documents of decks shuffled with a 64 bit seed keep it.
+	legacy := valid
+	legacy.Seed = new(int64)
+	*legacy.Seed = 8317405529641728291
+	imported, err := utils.ImportDeck(deckStore, legacy, "")
+	if err != nil {
+		t.Fatalf("failed to import a document with a 64 bit seed: %v", err)
+	}
+	history, _ := deckStore.History(imported.DeckID)
+	assert.Equal(t, models.HashSeed(*legacy.Seed), history[0].SeedHash)
+
+	for name, modify := range map[string]func(*utils.DeckExport){
+		"wrong_format":      func(doc *utils.DeckExport) { doc.Format = "something-else" },
+		"future_version":    func(doc *utils.DeckExport) { doc.FormatVersion = utils.ExportFormatVersion + 1 },
+		"bad_deck_id":       func(doc *utils.DeckExport) { doc.DeckID = "not-a-uuid" },
+		"unknown_card":      func(doc *utils.DeckExport) { doc.Remaining = []string{"KH", "ZZ"} },
+		"duplicate_card":    func(doc *utils.DeckExport) { doc.Remaining = []string{"KH", "AS"} },
+		"composition_drift": func(doc *utils.DeckExport) { doc.Composition = []string{"AS", "KH", "3D"} },
+		"short_seed":        func(doc *utils.DeckExport) { doc.ShuffleSeed = "0123456789abcdef" },
+		"both_seeds": func(doc *utils.DeckExport) {
+			doc.Seed, doc.ShuffleSeed = new(int64), strings.Repeat("ab", utils.ShuffleSeedSize)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			doc := valid
+			modify(&doc)
+			if _, err := utils.ImportDeck(deckStore, doc, ""); !errors.Is(err, utils.ErrInvalidImport) {
+				t.Errorf("expected ErrInvalidImport, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExportImportEndpoints(t *testing.T) {
+	deckStore := store.NewGormDeckStore(setupDB())
+	r := gin.New()
+	routes.RegisterDeckRoutes(r, deckStore)
+
+	deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D")
+	deckStore.Draw(deck.DeckID, 1)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/deck/"+deck.DeckID+"/export", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"format":"card-api/deck"`)
+
+	w2 := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/deck/import", strings.NewReader(w.Body.String()))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w2, req)
+	assert.Equal(t, http.StatusCreated, w2.Code)
+
+	var response controllers.DeckResponse
+	json.Unmarshal(w2.Body.Bytes(), &response)
+	assert.NotEqual(t, deck.DeckID, response.DeckID)
+	assert.Equal(t, 2, response.Remaining)
+
+	w3 := httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/deck/import", strings.NewReader(`{"format": "card-api/deck", "format_version": 9}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w3, req)
+	assert.Equal(t, http.StatusBadRequest, w3.Code)
+}
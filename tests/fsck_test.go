@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lando-ke/card-api/fsck"
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/routes"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker(t *testing.T) {
+	db := setupDB()
+	rowStore := store.NewGormDeckStore(db)
+	compactStore := store.NewGormDeckStore(db, store.WithCompactStorage())
+
+	healthy, _ := utils.NewDeck(rowStore, false, "AS,KH,2D")
+	rowStore.Draw(healthy.DeckID, 1)
+	miscounted, _ := utils.NewDeck(rowStore, false, "AS,KH,2D")
+	duplicated, _ := utils.NewDeck(rowStore, false, "AS,KH,2D")
+	compact, _ := utils.NewDeck(compactStore, false, "AS,KH,2D")
+
+	db.Model(&models.Deck{}).Where("deck_id = ?", miscounted.DeckID).UpdateColumn("remaining", 7)
+	db.Model(&models.Deck{}).Where("deck_id = ?", compact.DeckID).UpdateColumn("remaining", 0)
+	db.Model(&models.Card{}).Where("deck_id = ? AND code = ?", duplicated.DeckID, "2D").UpdateColumn("position", 0)
+	db.Create(&models.Card{Value: "ACE", Suit: "SPADES", Code: "AS", DeckID: "missing-deck"})
+
+	checker := fsck.NewChecker(db)
+
+	report, err := checker.Check()
+	if err != nil {
+		t.Fatalf("failed to check: %v", err)
+	}
+	assert.Equal(t, 4, report.DecksChecked)
+	kinds := map[string]string{}
+	for _, p := range report.Problems {
+		kinds[p.DeckID] = p.Kind
+		assert.False(t, p.Repaired)
+	}
+	assert.Equal(t, map[string]string{
+		miscounted.DeckID: fsck.RemainingMismatch,
+		compact.DeckID:    fsck.RemainingMismatch,
+		duplicated.DeckID: fsck.DuplicatePositions,
+		"missing-deck":    fsck.OrphanedCards,
+	}, kinds)
+
+	report, err = checker.Repair()
+	if err != nil {
+		t.Fatalf("failed to repair: %v", err)
+	}
+	assert.Len(t, report.Problems, 4)
+	assert.Equal(t, 0, report.Unrepaired())
+
+	report, _ = checker.Check()
+	assert.Empty(t, report.Problems)
+
+	deck, _ := rowStore.Get(duplicated.DeckID)
+	assertCodes(t, deck.Cards, "AS", "2D", "KH")
+	deck, _ = compactStore.Get(compact.DeckID)
+	assert.Equal(t, 3, deck.Remaining)
+}
+
+func TestFsckEndpoint(t *testing.T) {
+	db := setupDB()
+	r := gin.New()
+	routes.RegisterAdminRoutes(r, db, "secret", nil, nil, "")
+
+	deck, _ := utils.NewDeck(store.NewGormDeckStore(db), false, "AS,KH")
+	db.Model(&models.Deck{}).Where("deck_id = ?", deck.DeckID).UpdateColumn("remaining", 5)
+
+	request := func(method, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "/admin/fsck", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "wrong").Code)
+
+	// The token only counts as a bearer token.
+	bare := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/admin/fsck", nil)
+	req.Header.Set("Authorization", "secret")
+	r.ServeHTTP(bare, req)
+	assert.Equal(t, http.StatusUnauthorized, bare.Code)
+
+	w := request("GET", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var report fsck.Report
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Len(t, report.Problems, 1)
+	assert.False(t, report.Problems[0].Repaired)
+
+	w = request("POST", "secret")
+	assert.Equal(t, http.StatusOK, w.Code)
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.True(t, report.Problems[0].Repaired)
+
+	w = request("GET", "secret")
+	json.Unmarshal(w.Body.Bytes(), &report)
+	assert.Empty(t, report.Problems)
+}
+
+func TestFsckEndpoint_Actors(t *testing.T) {
+	db := setupDB()
+	actors := store.NewActorDeckStore(store.NewGormDeckStore(db), 64, time.Minute)
+	defer actors.Close()
+	r := gin.New()
+	routes.RegisterAdminRoutes(r, db, "secret", nil, actors, "")
+
+	deck, _ := utils.NewDeck(actors, false, "AS,KH")
+	actors.Draw(deck.DeckID, 1)
+
+	// A repair would change the deck behind the back of its actor.
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/admin/fsck", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
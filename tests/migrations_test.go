@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/lando-ke/card-api/database"
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type legacyCard struct {
+	gorm.Model
+	Value  string
+	Suit   string
+	Code   string
+	DeckID string `gorm:"index"`
+}
+
+func (legacyCard) TableName() string {
+	return "cards"
+}
+
+func TestRunMigrations_BackfillsCardPositions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	// A cards table as it was before cards had a position.
+	if err := db.AutoMigrate(&legacyCard{}); err != nil {
+		t.Fatalf("failed to create legacy table: %v", err)
+	}
+	for _, code := range []string{"AS", "KH", "2D"} {
+		db.Create(&legacyCard{Code: code, DeckID: "legacy-deck"})
+	}
+
+	if err := database.RunMigrations(db); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	var cards []models.Card
+	db.Where("deck_id = ?", "legacy-deck").Order("position ASC").Find(&cards)
+
+	expectedCards := []string{"AS", "KH", "2D"}
+	for i, card := range cards {
+		if card.Code != expectedCards[i] || card.Position != i {
+			t.Errorf("card at position %d should be %s, but is %s at position %d", i, expectedCards[i], card.Code, card.Position)
+		}
+	}
+}
+
+func TestMigrations_UpDownStatus(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	applied, err := database.MigrateUp(db)
+	if err != nil {
+		t.Fatalf("failed to migrate up: %v", err)
+	}
+	if len(applied) != database.LatestVersion() {
+		t.Errorf("expected %d migrations to be applied, got %d", database.LatestVersion(), len(applied))
+	}
+
+	// The migrated schema must be usable by the store.
+	deckStore := store.NewGormDeckStore(db)
+	deck, err := utils.NewDeck(deckStore, true, "")
+	if err != nil {
+		t.Fatalf("failed to create deck on the migrated schema: %v", err)
+	}
+	if _, err := deckStore.Draw(deck.DeckID, 2); err != nil {
+		t.Fatalf("failed to draw on the migrated schema: %v", err)
+	}
+
+	if applied, _ := database.MigrateUp(db); len(applied) != 0 {
+		t.Errorf("expected no pending migrations, got %d", len(applied))
+	}
+
+	reverted, err := database.MigrateDown(db, 1)
+	if err != nil {
+		t.Fatalf("failed to migrate down: %v", err)
+	}
+	if len(reverted) != 1 || reverted[0].Version != database.LatestVersion() {
+		t.Errorf("expected the latest migration to be reverted, got %v", reverted)
+	}
+
+	status, err := database.Status(db)
+	if err != nil {
+		t.Fatalf("failed to get migration status: %v", err)
+	}
+	for _, m := range status {
+		if m.Applied != (m.Version < database.LatestVersion()) {
+			t.Errorf("migration %d %s has applied=%v", m.Version, m.Name, m.Applied)
+		}
+	}
+
+	if _, err := database.MigrateDown(db, database.LatestVersion()); err != nil {
+		t.Fatalf("failed to migrate all the way down: %v", err)
+	}
+	if version, _ := database.SchemaVersion(db); version != 0 {
+		t.Errorf("expected schema version 0, got %d", version)
+	}
+	for _, table := range []string{"decks", "cards", "deck_operations"} {
+		if db.Migrator().HasTable(table) {
+			t.Errorf("table %s should have been dropped", table)
+		}
+	}
+
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("failed to migrate up again: %v", err)
+	}
+	if version, _ := database.SchemaVersion(db); version != database.LatestVersion() {
+		t.Errorf("expected schema version %d, got %d", database.LatestVersion(), version)
+	}
+}
+
+func TestMigrations_CardStates(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("failed to migrate up: %v", err)
+	}
+
+	deckStore := store.NewGormDeckStore(db)
+	deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D")
+	deckStore.Draw(deck.DeckID, 1)
+
+	// Rolling back to before cards had a state soft deletes the drawn
+	// cards, and migrating up again turns them back into drawn cards.
+	if _, err := database.MigrateDown(db, database.LatestVersion()-10); err != nil {
+		t.Fatalf("failed to migrate down: %v", err)
+	}
+	var deleted int64
+	db.Table("cards").Where("deck_id = ? AND deleted_at IS NOT NULL", deck.DeckID).Count(&deleted)
+	if deleted != 1 {
+		t.Errorf("expected 1 soft deleted card, got %d", deleted)
+	}
+
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("failed to migrate up again: %v", err)
+	}
+	var cards []models.Card
+	db.Unscoped().Where("deck_id = ?", deck.DeckID).Order("position ASC").Find(&cards)
+	for _, card := range cards {
+		expected := models.CardInDeck
+		if card.Code == "AS" {
+			expected = models.CardDrawn
+		}
+		if card.State != expected || card.DeletedAt.Valid {
+			t.Errorf("expected card %s to be %s and not deleted, got %s", card.Code, expected, card.State)
+		}
+	}
+
+	opened, _ := deckStore.Get(deck.DeckID)
+	assertCodes(t, opened.Cards, "KH", "2D")
+}
+
+func TestMigrations_BackfillsDeckVersions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("failed to migrate up: %v", err)
+	}
+
+	deckStore := store.NewGormDeckStore(db)
+	deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D")
+	deckStore.Draw(deck.DeckID, 1)
+	deckStore.Draw(deck.DeckID, 1)
+
+	// Rolling back to before decks had a version and migrating up again
+	// gives the deck the version its three events add up to.
+	if _, err := database.MigrateDown(db, database.LatestVersion()-5); err != nil {
+		t.Fatalf("failed to migrate down: %v", err)
+	}
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("failed to migrate up again: %v", err)
+	}
+
+	stored, _ := deckStore.Get(deck.DeckID)
+	if stored.Version != 3 {
+		t.Errorf("expected version 3, got %d", stored.Version)
+	}
+}
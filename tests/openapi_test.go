@@ -0,0 +1,273 @@
+package tests
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lando-ke/card-api/controllers"
+	"github.com/lando-ke/card-api/database"
+	"github.com/lando-ke/card-api/docs"
+	"github.com/lando-ke/card-api/fsck"
+	"github.com/lando-ke/card-api/routes"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type openAPIParameter struct {
+	Ref  string `json:"$ref"`
+	Name string `json:"name"`
+	In   string `json:"in"`
+}
+
+type openAPISpec struct {
+	Paths map[string]map[string]struct {
+		Parameters []openAPIParameter `json:"parameters"`
+	} `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+		Parameters map[string]openAPIParameter `json:"parameters"`
+	} `json:"components"`
+}
+
+func loadOpenAPISpec(t *testing.T) openAPISpec {
+	var spec openAPISpec
+	if err := json.Unmarshal(docs.OpenAPI, &spec); err != nil {
+		t.Fatalf("failed to parse the OpenAPI document: %v", err)
+	}
+	return spec
+}
+
+var pathParam = regexp.MustCompile(`:([a-z_]+)`)
+
+// TestOpenAPI_Routes fails when a route is missing from the specification,
+// the specification describes a route that is not served, or the path, query
+// and header parameters a handler reads differ from those documented for its
+// route.
+func TestOpenAPI_Routes(t *testing.T) {
+	db := setupDB()
+	r := gin.New()
+	routes.RegisterDeckRoutes(r, store.NewMemoryDeckStore())
+	routes.RegisterAdminRoutes(r, db, "secret", nil, nil, "")
+	routes.RegisterDocsRoutes(r)
+
+	served := []string{}
+	for _, route := range r.Routes() {
+		// The unversioned aliases are described once, under /v1.
+		if strings.HasPrefix(route.Path, "/deck") {
+			continue
+		}
+		served = append(served, route.Method+" "+pathParam.ReplaceAllString(route.Path, "{$1}"))
+	}
+
+	documented := []string{}
+	for path, operations := range loadOpenAPISpec(t).Paths {
+		for method := range operations {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	sort.Strings(served)
+	sort.Strings(documented)
+	assert.Equal(t, served, documented)
+
+	spec := loadOpenAPISpec(t)
+	read := handlerParameters(t)
+	for _, route := range r.Routes() {
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+		operation, ok := spec.Paths[path][strings.ToLower(route.Method)]
+		if !ok {
+			continue
+		}
+
+		handler := strings.TrimSuffix(route.Handler[strings.LastIndex(route.Handler, ".")+1:], "-fm")
+		expected := read[handler]
+		if expected == nil {
+			expected = []string{}
+		}
+		parameters := []string{}
+		for _, parameter := range operation.Parameters {
+			if parameter.Ref != "" {
+				parameter = spec.Components.Parameters[strings.TrimPrefix(parameter.Ref, "#/components/parameters/")]
+			}
+			parameters = append(parameters, parameter.In+" "+parameter.Name)
+		}
+		sort.Strings(parameters)
+		assert.Equal(t, expected, parameters, "parameters of %s %s", route.Method, path)
+	}
+}
+
+// handlerParameters returns, by function name, the sorted parameters each
+// function of the controllers package reads itself or through the functions
+// it calls, such as "header X-Owner-Token".
+func handlerParameters(t *testing.T) map[string][]string {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, "../controllers", nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse the controllers: %v", err)
+	}
+
+	consts := map[string]string{}
+	funcs := map[string]*ast.FuncDecl{}
+	for _, file := range pkgs["controllers"].Files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				funcs[decl.Name.Name] = decl
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					value, ok := spec.(*ast.ValueSpec)
+					if !ok || decl.Tok != token.CONST {
+						continue
+					}
+					for i, name := range value.Names {
+						if lit, ok := value.Values[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+							consts[name.Name], _ = strconv.Unquote(lit.Value)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	locations := map[string]string{
+		"Param":        "path",
+		"Query":        "query",
+		"DefaultQuery": "query",
+		"GetHeader":    "header",
+	}
+
+	direct := map[string]map[string]bool{}
+	calls := map[string][]string{}
+	for name, fn := range funcs {
+		direct[name] = map[string]bool{}
+		ast.Inspect(fn, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			var callee string
+			switch fun := call.Fun.(type) {
+			case *ast.Ident:
+				callee = fun.Name
+			case *ast.SelectorExpr:
+				callee = fun.Sel.Name
+				if in, ok := locations[callee]; ok && len(call.Args) > 0 {
+					switch arg := call.Args[0].(type) {
+					case *ast.BasicLit:
+						value, _ := strconv.Unquote(arg.Value)
+						direct[name][in+" "+value] = true
+					case *ast.Ident:
+						direct[name][in+" "+consts[arg.Name]] = true
+					}
+					return true
+				}
+			}
+			if _, ok := funcs[callee]; ok {
+				calls[name] = append(calls[name], callee)
+			}
+			return true
+		})
+	}
+
+	read := map[string][]string{}
+	for name := range funcs {
+		seen := map[string]bool{}
+		parameters := map[string]bool{}
+		var visit func(string)
+		visit = func(name string) {
+			if seen[name] {
+				return
+			}
+			seen[name] = true
+			for parameter := range direct[name] {
+				parameters[parameter] = true
+			}
+			for _, callee := range calls[name] {
+				visit(callee)
+			}
+		}
+		visit(name)
+
+		read[name] = []string{}
+		for parameter := range parameters {
+			read[name] = append(read[name], parameter)
+		}
+		sort.Strings(read[name])
+	}
+	return read
+}
+
+// TestOpenAPI_Schemas fails when the JSON fields of a response or request
+// type differ from the properties of its schema.
+func TestOpenAPI_Schemas(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+
+	for name, value := range map[string]interface{}{
+		"CardResponse":      controllers.CardResponse{},
+		"DeckResponse":      controllers.DeckResponse{},
+		"CreateDeckRequest": controllers.CreateDeckRequest{},
+		"OperationResponse": controllers.OperationResponse{},
+		"HistoryResponse":   controllers.HistoryResponse{},
+		"SnapshotRequest":   controllers.SnapshotRequest{},
+		"SnapshotResponse":  controllers.SnapshotResponse{},
+		"SnapshotsResponse": controllers.SnapshotsResponse{},
+		"DeckExport":        utils.DeckExport{},
+		"FsckProblem":       fsck.Problem{},
+		"FsckReport":        fsck.Report{},
+		"CacheStats":        store.CacheStats{},
+		"BackupFile":        database.BackupFile{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			schema, ok := spec.Components.Schemas[name]
+			if !ok {
+				t.Fatalf("schema %s is missing", name)
+			}
+
+			fields := []string{}
+			typ := reflect.TypeOf(value)
+			for i := 0; i < typ.NumField(); i++ {
+				tag := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+				if tag != "" && tag != "-" {
+					fields = append(fields, tag)
+				}
+			}
+			properties := []string{}
+			for property := range schema.Properties {
+				properties = append(properties, property)
+			}
+
+			sort.Strings(fields)
+			sort.Strings(properties)
+			assert.Equal(t, fields, properties)
+		})
+	}
+}
+
+func TestDocsRoutes(t *testing.T) {
+	r := gin.New()
+	routes.RegisterDocsRoutes(r)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"openapi": "3.0.3"`)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/docs", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+}
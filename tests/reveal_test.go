@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lando-ke/card-api/controllers"
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/routes"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevealedCards(t *testing.T) {
+	deck := models.Deck{Cards: []models.Card{
+		{Value: "KING", Suit: "HEARTS", Code: "KH"},
+		{Value: "2", Suit: "SPADES", Code: "2S"},
+		{Value: "ACE", Suit: "SPADES", Code: "AS"},
+	}}
+
+	for policy, expected := range map[string][]string{
+		"":                           {"KH", "2S", "AS"},
+		models.RevealFull:            {"KH", "2S", "AS"},
+		models.RevealCompositionOnly: {"2S", "AS", "KH"},
+		models.RevealCountOnly:       {},
+		models.RevealOwnerOnly:       {},
+	} {
+		t.Run(policy, func(t *testing.T) {
+			deck.RevealPolicy = policy
+			assertCodes(t, utils.RevealedCards(deck, ""), expected...)
+		})
+	}
+
+	deck.RevealPolicy = models.RevealOwnerOnly
+	deck.OwnerTokenHash = models.HashOwnerToken("secret")
+	assertCodes(t, utils.RevealedCards(deck, "secret"), "KH", "2S", "AS")
+	assertCodes(t, utils.RevealedCards(deck, "guess"))
+}
+
+func TestRevealPolicyEndpoints(t *testing.T) {
+	r := gin.New()
+	routes.RegisterDeckRoutes(r, store.NewGormDeckStore(setupDB()))
+
+	request := func(method, path, body string, header map[string]string) (*httptest.ResponseRecorder, controllers.DeckResponse) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		r.ServeHTTP(w, req)
+
+		var response controllers.DeckResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w, response
+	}
+
+	t.Run("composition_only", func(t *testing.T) {
+		w, created := request("POST", "/deck", `{"cards": ["KH", "2S", "AS"], "reveal_policy": "composition_only"}`, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, models.RevealCompositionOnly, created.RevealPolicy)
+		assert.Empty(t, created.OwnerToken)
+
+		_, opened := request("GET", "/deck/"+created.DeckID, "", nil)
+		assert.Equal(t, 3, opened.Remaining)
+		assert.Equal(t, []controllers.CardResponse{
+			{Value: "2", Suit: "SPADES", Code: "2S"},
+			{Value: "ACE", Suit: "SPADES", Code: "AS"},
+			{Value: "KING", Suit: "HEARTS", Code: "KH"},
+		}, opened.Cards)
+
+		w, _ = request("GET", "/deck/"+created.DeckID+"/export", "", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		// The fingerprints would tell which of the few possible orders the
+		// deck is in.
+		w, _ = request("POST", "/deck/"+created.DeckID+"/snapshots", `{"name": "start"}`, nil)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NotContains(t, w.Body.String(), "fingerprint")
+		w, _ = request("GET", "/deck/"+created.DeckID+"/snapshots", "", nil)
+		assert.NotContains(t, w.Body.String(), "fingerprint")
+		w, _ = request("GET", "/deck/"+created.DeckID+"/history", "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "fingerprint")
+
+		// Drawing again after an undo or a restore would show the cards
+		// in order.
+		request("GET", "/deck/"+created.DeckID+"/draw?count=1", "", nil)
+		w, _ = request("POST", "/deck/"+created.DeckID+"/undo", "", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w, _ = request("POST", "/deck/"+created.DeckID+"/snapshots/start/restore", "", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("count_only", func(t *testing.T) {
+		_, created := request("POST", "/deck?reveal_policy=count_only&shuffled=true", "", nil)
+		assert.Empty(t, created.Cards)
+
+		_, opened := request("GET", "/deck/"+created.DeckID+"?at=1", "", nil)
+		assert.Equal(t, 52, opened.Remaining)
+		assert.Empty(t, opened.Cards)
+	})
+
+	t.Run("owner_only", func(t *testing.T) {
+		_, created := request("POST", "/deck?cards=KH,2S,AS&reveal_policy=owner_only", "", nil)
+		assert.NotEmpty(t, created.OwnerToken)
+		assert.Len(t, created.Cards, 3)
+
+		_, opened := request("GET", "/deck/"+created.DeckID, "", nil)
+		assert.Empty(t, opened.Cards)
+		assert.Empty(t, opened.OwnerToken)
+		_, opened = request("GET", "/deck/"+created.DeckID, "", map[string]string{controllers.OwnerTokenHeader: "guess"})
+		assert.Empty(t, opened.Cards)
+
+		owner := map[string]string{controllers.OwnerTokenHeader: created.OwnerToken}
+		_, opened = request("GET", "/deck/"+created.DeckID, "", owner)
+		assert.Equal(t, "KH", opened.Cards[0].Code)
+
+		_, shuffled := request("POST", "/deck/"+created.DeckID+"/shuffle", "", nil)
+		assert.Empty(t, shuffled.Cards)
+
+		w, _ := request("GET", "/deck/"+created.DeckID+"/history", "", nil)
+		assert.NotContains(t, w.Body.String(), "fingerprint")
+		w, _ = request("GET", "/deck/"+created.DeckID+"/history", "", owner)
+		assert.Contains(t, w.Body.String(), "fingerprint")
+
+		request("GET", "/deck/"+created.DeckID+"/draw?count=1", "", nil)
+		w, _ = request("POST", "/deck/"+created.DeckID+"/undo", "", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		w, undone := request("POST", "/deck/"+created.DeckID+"/undo", "", owner)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Len(t, undone.Cards, 3)
+
+		w, _ = request("GET", "/deck/"+created.DeckID+"/export", "", owner)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// The imported copy belongs to whoever imported it.
+		w, imported := request("POST", "/deck/import", w.Body.String(), nil)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, models.RevealOwnerOnly, imported.RevealPolicy)
+		assert.NotEmpty(t, imported.OwnerToken)
+		assert.NotEqual(t, created.OwnerToken, imported.OwnerToken)
+	})
+
+	t.Run("invalid_policy", func(t *testing.T) {
+		w, _ := request("POST", "/deck?reveal_policy=peek", "", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lando-ke/card-api/controllers"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeckSnapshots(t *testing.T) {
+	for name, deckStore := range deckStores() {
+		t.Run(name, func(t *testing.T) {
+			deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D,JC,10C")
+			deckStore.Draw(deck.DeckID, 1)
+
+			snapshot, err := utils.SaveSnapshot(deckStore, deck.DeckID, "checkpoint")
+			if err != nil {
+				t.Fatalf("failed to save snapshot: %v", err)
+			}
+			if snapshot.Remaining != 4 || snapshot.Drawn != "AS" {
+				t.Errorf("expected 4 remaining and AS drawn, got %d remaining and %q drawn", snapshot.Remaining, snapshot.Drawn)
+			}
+
+			utils.ShuffleDeck(deckStore, deck.DeckID, utils.ShuffleOptions{})
+			deckStore.Draw(deck.DeckID, 3)
+
+			restored, err := utils.RestoreSnapshot(deckStore, deck.DeckID, "checkpoint")
+			if err != nil {
+				t.Fatalf("failed to restore snapshot: %v", err)
+			}
+			assertCodes(t, restored.Cards, "KH", "2D", "JC", "10C")
+			if restored.Shuffled {
+				t.Errorf("expected the restored deck to be unshuffled")
+			}
+
+			// A restore can be restored again, and shows up in the history.
+			deckStore.Draw(deck.DeckID, 4)
+			restored, _ = utils.RestoreSnapshot(deckStore, deck.DeckID, "checkpoint")
+			assertCodes(t, restored.Cards, "KH", "2D", "JC", "10C")
+
+			history, _ := deckStore.History(deck.DeckID)
+			replayed, err := utils.ReplayDeck(deck.DeckID, history)
+			if err != nil {
+				t.Fatalf("failed to replay deck: %v", err)
+			}
+			assertCodes(t, replayed.Cards, "KH", "2D", "JC", "10C")
+
+			// Saving under an existing name replaces the snapshot.
+			deckStore.Draw(deck.DeckID, 2)
+			utils.SaveSnapshot(deckStore, deck.DeckID, "checkpoint")
+			snapshots, err := deckStore.Snapshots(deck.DeckID)
+			if err != nil {
+				t.Fatalf("failed to list snapshots: %v", err)
+			}
+			if len(snapshots) != 1 || snapshots[0].Remaining != 2 {
+				t.Errorf("expected one snapshot with 2 cards remaining, got %v", snapshots)
+			}
+
+			if _, err := deckStore.Snapshot(deck.DeckID, "missing"); !errors.Is(err, store.ErrSnapshotNotFound) {
+				t.Errorf("expected ErrSnapshotNotFound, got %v", err)
+			}
+			if _, err := utils.SaveSnapshot(deckStore, deck.DeckID, "no/slashes"); !errors.Is(err, utils.ErrInvalidSnapshotName) {
+				t.Errorf("expected ErrInvalidSnapshotName, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSnapshotEndpoints(t *testing.T) {
+	deckStore := store.NewGormDeckStore(setupDB())
+	dc := controllers.NewDeckController(deckStore)
+
+	deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D")
+
+	request := func(handler gin.HandlerFunc, method, path, body string, params ...gin.Param) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(method, path, strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Params = append([]gin.Param{{Key: "deck_id", Value: deck.DeckID}}, params...)
+		handler(c)
+		return w
+	}
+
+	t.Run("save_list_restore", func(t *testing.T) {
+		w := request(dc.SaveSnapshot, "POST", "/deck/"+deck.DeckID+"/snapshots", `{"name": "start"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		deckStore.Draw(deck.DeckID, 2)
+
+		w = request(dc.ListSnapshots, "GET", "/deck/"+deck.DeckID+"/snapshots", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var list controllers.SnapshotsResponse
+		json.Unmarshal(w.Body.Bytes(), &list)
+		assert.Len(t, list.Snapshots, 1)
+		assert.Equal(t, "start", list.Snapshots[0].Name)
+		assert.Equal(t, 3, list.Snapshots[0].Remaining)
+
+		w = request(dc.RestoreSnapshot, "POST", "/deck/"+deck.DeckID+"/snapshots/start/restore", "", gin.Param{Key: "name", Value: "start"})
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response controllers.DeckResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, 3, response.Remaining)
+		assert.Equal(t, "AS", response.Cards[0].Code)
+	})
+
+	t.Run("invalid_name", func(t *testing.T) {
+		w := request(dc.SaveSnapshot, "POST", "/deck/"+deck.DeckID+"/snapshots", `{"name": ""}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("restore_missing_snapshot", func(t *testing.T) {
+		w := request(dc.RestoreSnapshot, "POST", "/deck/"+deck.DeckID+"/snapshots/missing/restore", "", gin.Param{Key: "name", Value: "missing"})
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
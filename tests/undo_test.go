@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lando-ke/card-api/controllers"
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/store"
+	"github.com/lando-ke/card-api/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUndoDeck(t *testing.T) {
+	for name, deckStore := range deckStores() {
+		t.Run(name, func(t *testing.T) {
+			deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D,JC,10C")
+			deckStore.Draw(deck.DeckID, 1)
+			utils.ShuffleDeck(deckStore, deck.DeckID, utils.ShuffleOptions{})
+			deckStore.Draw(deck.DeckID, 2)
+
+			undone, err := utils.UndoDeck(deckStore, deck.DeckID, 2, 10)
+			if err != nil {
+				t.Fatalf("failed to undo: %v", err)
+			}
+			assertCodes(t, undone.Cards, "KH", "2D", "JC", "10C")
+			if undone.Shuffled {
+				t.Errorf("expected undoing the shuffle to unshuffle the deck")
+			}
+
+			stored, _ := deckStore.Get(deck.DeckID)
+			assertCodes(t, stored.Cards, "KH", "2D", "JC", "10C")
+
+			undone, err = utils.UndoDeck(deckStore, deck.DeckID, 1, 10)
+			if err != nil {
+				t.Fatalf("failed to undo the first draw: %v", err)
+			}
+			assertCodes(t, undone.Cards, "AS", "KH", "2D", "JC", "10C")
+
+			if _, err := utils.UndoDeck(deckStore, deck.DeckID, 1, 10); !errors.Is(err, utils.ErrInvalidUndo) {
+				t.Errorf("expected the creation of the deck not to be undoable, got %v", err)
+			}
+
+			// Undos are recorded, so the deck can still be rebuilt from its
+			// history.
+			history, _ := deckStore.History(deck.DeckID)
+			if last := history[len(history)-1]; last.Method != models.MethodUndo || last.Count != 1 {
+				t.Errorf("expected the last event to undo 1 operation, got %s %d", last.Method, last.Count)
+			}
+			replayed, err := utils.ReplayDeck(deck.DeckID, history)
+			if err != nil {
+				t.Fatalf("failed to replay deck: %v", err)
+			}
+			assertCodes(t, replayed.Cards, "AS", "KH", "2D", "JC", "10C")
+		})
+	}
+}
+
+func TestUndoDeck_Depth(t *testing.T) {
+	deckStore := store.NewMemoryDeckStore()
+	deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D,JC,10C")
+	for i := 0; i < 4; i++ {
+		deckStore.Draw(deck.DeckID, 1)
+	}
+
+	if _, err := utils.UndoDeck(deckStore, deck.DeckID, 3, 2); !errors.Is(err, utils.ErrInvalidUndo) {
+		t.Errorf("expected undoing past the depth to fail, got %v", err)
+	}
+
+	// Undoing one operation at a time cannot reach past the depth either.
+	for i := 0; i < 2; i++ {
+		if _, err := utils.UndoDeck(deckStore, deck.DeckID, 1, 2); err != nil {
+			t.Fatalf("failed to undo within the depth: %v", err)
+		}
+	}
+	if _, err := utils.UndoDeck(deckStore, deck.DeckID, 1, 2); !errors.Is(err, utils.ErrInvalidUndo) {
+		t.Errorf("expected a third undo to fail with a depth of 2, got %v", err)
+	}
+
+	stored, _ := deckStore.Get(deck.DeckID)
+	assertCodes(t, stored.Cards, "2D", "JC", "10C")
+}
+
+func TestUndoDeck_VersionBehindHistory(t *testing.T) {
+	db := setupDB()
+	deckStore := store.NewGormDeckStore(db)
+	deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D")
+	deckStore.Draw(deck.DeckID, 1)
+
+	// Decks that had events before they had versions started at version 1.
+	db.Model(&models.Deck{}).Where("deck_id = ?", deck.DeckID).UpdateColumn("version", 1)
+
+	undone, err := utils.UndoDeck(deckStore, deck.DeckID, 1, 10)
+	if err != nil {
+		t.Fatalf("failed to undo: %v", err)
+	}
+	assertCodes(t, undone.Cards, "AS", "KH", "2D")
+}
+
+func TestUndoEndpoint(t *testing.T) {
+	deckStore := store.NewGormDeckStore(setupDB())
+	dc := controllers.NewDeckController(deckStore, controllers.WithUndoDepth(3))
+
+	undo := func(deckID, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/deck/"+deckID+"/undo"+query, nil)
+		c.Params = []gin.Param{{Key: "deck_id", Value: deckID}}
+		dc.UndoDeck(c)
+		return w
+	}
+
+	t.Run("undo_steps", func(t *testing.T) {
+		deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D,JC")
+		deckStore.Draw(deck.DeckID, 1)
+		deckStore.Draw(deck.DeckID, 1)
+
+		w := undo(deck.DeckID, "?steps=2")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response controllers.DeckResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.Equal(t, 4, response.Remaining)
+		assert.Equal(t, "AS", response.Cards[0].Code)
+		assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+	})
+
+	t.Run("undo_too_far", func(t *testing.T) {
+		deck, _ := utils.NewDeck(deckStore, false, "AS,KH,2D,JC")
+		for i := 0; i < 4; i++ {
+			deckStore.Draw(deck.DeckID, 1)
+		}
+
+		assert.Equal(t, http.StatusBadRequest, undo(deck.DeckID, "?steps=4").Code)
+		assert.Equal(t, http.StatusBadRequest, undo(deck.DeckID, "?steps=0").Code)
+	})
+
+	t.Run("undo_non_existent_deck", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, undo("nonexistentdeck123", "").Code)
+	})
+}
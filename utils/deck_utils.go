@@ -1,65 +1,85 @@
 package utils
 
 import (
-	"math/rand"
 	"strings"
-	"time"
 	"fmt"
-	"gorm.io/gorm"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/store"
 )
 
 var (
-	values = []string{"2", "3", "4", "5", "6", "7", "8", "9", "10", "JACK", "QUEEN", "KING", "ACE"}
-	suits  = []string{"SPADES", "DIAMONDS", "CLUBS", "HEARTS"}
+	values = models.CardValues
+	suits  = models.CardSuits
 )
 
-func NewDeck(db *gorm.DB, shuffled bool, cardsParam string) (models.Deck, error) {
+// DeckOptions are the settings a deck is created with.
+type DeckOptions struct {
+	Shuffled bool
+	// TTL expires the deck this long after its creation. Zero leaves the
+	// deck to the server-wide idle TTL.
+	TTL time.Duration
+	// RevealPolicy is one of models.RevealPolicies, models.RevealFull when
+	// empty.
+	RevealPolicy string
+	// OwnerToken is the token of the owner of a models.RevealOwnerOnly deck.
+	OwnerToken string
+}
+
+func NewDeck(s store.DeckStore, shuffled bool, cardsParam string) (models.Deck, error) {
+	return CreateDeck(s, CardsFromParam(cardsParam), DeckOptions{Shuffled: shuffled})
+}
+
+// NewDeckFromCards creates a deck holding cards. Unless shuffled is set the
+// deck is drawn in exactly the order the cards are given.
+func NewDeckFromCards(s store.DeckStore, shuffled bool, cards []models.Card) (models.Deck, error) {
+	return CreateDeck(s, cards, DeckOptions{Shuffled: shuffled})
+}
+
+// CreateDeck creates a deck holding cards with the given options. Unless the
+// deck is shuffled it is drawn in exactly the order the cards are given.
+func CreateDeck(s store.DeckStore, cards []models.Card, opts DeckOptions) (models.Deck, error) {
 	deck := models.Deck{
-		DeckID:   uuid.New().String(),
-		Shuffled: shuffled,
+		DeckID:       uuid.New().String(),
+		Shuffled:     opts.Shuffled,
+		RevealPolicy: opts.RevealPolicy,
+	}
+	if deck.RevealPolicy == "" {
+		deck.RevealPolicy = models.RevealFull
+	}
+	if opts.OwnerToken != "" {
+		deck.OwnerTokenHash = models.HashOwnerToken(opts.OwnerToken)
 	}
 
-	// Create cards and associate them with the deck
-	cards := createCards(shuffled, cardsParam)
-	deck.Remaining = len(cards) // Set the remaining count dynamically based on the created cards
-
-	// Save the deck to the database
-	if err := db.Create(&deck).Error; err != nil {
-		return models.Deck{}, err
+	if opts.TTL > 0 {
+		expiresAt := time.Now().Add(opts.TTL)
+		deck.ExpiresAt = &expiresAt
 	}
 
-	for _, card := range cards {
-		card.DeckID = deck.DeckID
-		if err := db.Create(&card).Error; err != nil {
-			return models.Deck{}, err
-		}
+	// Associate the cards with the deck
+	var seed []byte
+	if opts.Shuffled {
+		seed = NewShuffleSeed()
+		cards, _ = ShuffleCards(cards, seed, ShuffleOptions{})
 	}
+	deck.Cards = cards
 
-	// Retrieve the cards associated with the deck and set the Cards field
-	if err := db.Where("deck_id = ?", deck.DeckID).Find(&deck.Cards).Error; err != nil {
+	if err := s.Create(&deck, models.NewDeckOperation(models.MethodCreate, len(cards), seed, cards)); err != nil {
 		return models.Deck{}, err
 	}
 
 	return deck, nil
 }
 
-func createCards(shuffled bool, cardsParam string) []models.Card {
-	var cards []models.Card
-
+// CardsFromParam returns the cards listed in cardsParam, or a full deck when
+// it is empty.
+func CardsFromParam(cardsParam string) []models.Card {
 	if cardsParam != "" {
-		cards = CreatePartialDeck(cardsParam)
-	} else {
-		cards = CreateFullDeck()
-	}
-
-	if shuffled {
-		cards = ShuffleCards(cards)
+		return CreatePartialDeck(cardsParam)
 	}
-
-	return cards
+	return CreateFullDeck()
 }
 
 func CreateFullDeck() []models.Card {
@@ -83,53 +103,73 @@ func CreatePartialDeck(cardsParam string) []models.Card {
 	cardCodes := strings.Split(cardsParam, ",")
 	cards := []models.Card{}
 
-	codeToValueSuit := make(map[string]struct {
-		Value string
-		Suit  string
-	})
-
-	for _, suit := range suits {
-		for _, value := range values {
-			code := value[:1] + suit[:1]
-			if value == "10" {
-				code = value + suit[:1]
-			}
-			codeToValueSuit[code] = struct {
-				Value string
-				Suit  string
-			}{Value: value, Suit: suit}
-		}
-	}
+	codeToCard := cardsByCode()
 
 	for _, cardCode := range cardCodes {
 		cardCode = strings.ToUpper(cardCode)
-		valueSuit, ok := codeToValueSuit[cardCode]
+		card, ok := codeToCard[cardCode]
 		if !ok {
 			continue
 		}
 
-		card := models.Card{
-			Value: valueSuit.Value,
-			Suit:  valueSuit.Suit,
-			Code:  cardCode,
-		}
-
 		cards = append(cards, card)
 	}
 
 	return cards
 }
 
+// CreateStackedDeck builds the cards for codes in exactly the given order.
+// Unlike CreatePartialDeck it never skips a code: when any code is unknown or
+// repeated no cards are returned, only the offending codes.
+func CreateStackedDeck(codes []string) ([]models.Card, []string) {
+	codeToCard := cardsByCode()
+	cards := []models.Card{}
+	invalidCards := []string{}
+	seen := make(map[string]bool)
+
+	for _, code := range codes {
+		code = strings.ToUpper(code)
+		card, ok := codeToCard[code]
+		switch {
+		case !ok:
+			invalidCards = append(invalidCards, code)
+		case seen[code]:
+			invalidCards = append(invalidCards, fmt.Sprintf("%s (duplicate)", code))
+		default:
+			seen[code] = true
+			cards = append(cards, card)
+		}
+	}
 
-func ShuffleCards(cards []models.Card) []models.Card {
-	rand.Seed(time.Now().UnixNano())
-	rand.Shuffle(len(cards), func(i, j int) {
-		cards[i], cards[j] = cards[j], cards[i]
-	})
+	if len(invalidCards) > 0 {
+		return nil, invalidCards
+	}
 
-	return cards
+	return cards, nil
 }
 
+// cardsByCode maps every valid card code to its card.
+func cardsByCode() map[string]models.Card {
+	codeToCard := make(map[string]models.Card)
+
+	for _, suit := range suits {
+		for _, value := range values {
+			code := value[:1] + suit[:1]
+			if value == "10" {
+				code = value + suit[:1]
+			}
+			codeToCard[code] = models.Card{
+				Value: value,
+				Suit:  suit,
+				Code:  code,
+			}
+		}
+	}
+
+	return codeToCard
+}
+
+
 func generateDeckID() string {
 	uuid, _ := uuid.NewRandom()
 	return uuid.String()
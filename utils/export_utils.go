@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/store"
+)
+
+const (
+	// ExportFormat identifies deck export documents.
+	ExportFormat = "card-api/deck"
+	// ExportFormatVersion is the version of the export format written by
+	// ExportDeck. ImportDeck reads every version up to this one.
+	ExportFormatVersion = 1
+)
+
+// ErrInvalidImport is returned for an import document that does not describe
+// a valid deck.
+var ErrInvalidImport = errors.New("invalid deck document")
+
+// DeckExport is the portable description of a deck and its state.
+type DeckExport struct {
+	Format        string `json:"format"`
+	FormatVersion int    `json:"format_version"`
+	DeckID        string `json:"deck_id"`
+	Shuffled      bool   `json:"shuffled"`
+	// ShuffleSeed is the hex encoded seed the deck was shuffled with when
+	// it was created. Seed holds it instead for decks shuffled before seeds
+	// were 32 bytes long.
+	Seed        *int64 `json:"seed,omitempty"`
+	ShuffleSeed string `json:"shuffle_seed,omitempty"`
+	// Composition lists the cards the deck was created with.
+	Composition []string `json:"composition"`
+	// Remaining lists the cards left in the deck in draw order, and Drawn
+	// the cards no longer in it.
+	Remaining  []string   `json:"remaining"`
+	Drawn      []string   `json:"drawn"`
+	Version    int        `json:"version"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	ExportedAt time.Time  `json:"exported_at"`
+	// RevealPolicy is the reveal policy of the deck. Documents written
+	// before decks had one leave it out, and are imported as
+	// models.RevealFull.
+	RevealPolicy string `json:"reveal_policy,omitempty"`
+}
+
+// ExportDeck describes the current state of a deck as a DeckExport.
+func ExportDeck(s store.DeckStore, deckID string) (DeckExport, error) {
+	deck, err := s.Get(deckID)
+	if err != nil {
+		return DeckExport{}, err
+	}
+	history, err := s.History(deckID)
+	if err != nil {
+		return DeckExport{}, err
+	}
+
+	remaining := models.CardCodes(deck.Cards)
+	live := make(map[string]bool, len(remaining))
+	for _, code := range remaining {
+		live[code] = true
+	}
+
+	// Decks recorded before the history held cards only know their
+	// remaining cards.
+	composition := remaining
+	var seed *int64
+	shuffleSeed := ""
+	if len(history) > 0 && history[0].Method == models.MethodCreate {
+		seed, shuffleSeed = history[0].Seed, history[0].ShuffleSeed
+		if history[0].Cards != "" {
+			composition = strings.Split(history[0].Cards, ",")
+		}
+	}
+
+	drawn := []string{}
+	for _, code := range composition {
+		if !live[code] {
+			drawn = append(drawn, code)
+		}
+	}
+
+	return DeckExport{
+		Format:        ExportFormat,
+		FormatVersion: ExportFormatVersion,
+		DeckID:        deck.DeckID,
+		Shuffled:      deck.Shuffled,
+		Seed:          seed,
+		ShuffleSeed:   shuffleSeed,
+		Composition:   composition,
+		Remaining:     remaining,
+		Drawn:         drawn,
+		Version:       deck.Version,
+		CreatedAt:     deck.CreatedAt,
+		UpdatedAt:     deck.UpdatedAt,
+		ExpiresAt:     deck.ExpiresAt,
+		ExportedAt:    time.Now(),
+		RevealPolicy:  deck.RevealPolicy,
+	}, nil
+}
+
+// ValidateExport checks that doc describes a deck that can be imported.
+func ValidateExport(doc DeckExport) error {
+	if doc.Format != ExportFormat {
+		return fmt.Errorf("%w: format must be %q", ErrInvalidImport, ExportFormat)
+	}
+	if doc.FormatVersion < 1 || doc.FormatVersion > ExportFormatVersion {
+		return fmt.Errorf("%w: unsupported format version %d", ErrInvalidImport, doc.FormatVersion)
+	}
+	if doc.DeckID != "" {
+		if _, err := uuid.Parse(doc.DeckID); err != nil {
+			return fmt.Errorf("%w: deck_id is not a UUID", ErrInvalidImport)
+		}
+	}
+	if doc.ShuffleSeed != "" {
+		if doc.Seed != nil {
+			return fmt.Errorf("%w: seed and shuffle_seed cannot both be set", ErrInvalidImport)
+		}
+		if seed, err := hex.DecodeString(doc.ShuffleSeed); err != nil || len(seed) != ShuffleSeedSize {
+			return fmt.Errorf("%w: shuffle_seed must be %d hex encoded bytes", ErrInvalidImport, ShuffleSeedSize)
+		}
+	}
+	if doc.RevealPolicy != "" && !models.ValidRevealPolicy(doc.RevealPolicy) {
+		return fmt.Errorf("%w: unknown reveal policy %q", ErrInvalidImport, doc.RevealPolicy)
+	}
+	if doc.ExpiresAt != nil && !doc.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("%w: deck expired at %s", ErrInvalidImport, doc.ExpiresAt.Format(time.RFC3339))
+	}
+
+	codes := append(append([]string{}, doc.Remaining...), doc.Drawn...)
+	if _, err := cardsForCodes(doc.DeckID, codes); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+	if code := duplicateCode(codes); code != "" {
+		return fmt.Errorf("%w: card %s appears more than once", ErrInvalidImport, code)
+	}
+
+	if len(doc.Composition) > 0 {
+		if len(doc.Composition) != len(codes) {
+			return fmt.Errorf("%w: remaining and drawn cards do not add up to the composition", ErrInvalidImport)
+		}
+		inDeck := make(map[string]bool, len(codes))
+		for _, code := range codes {
+			inDeck[code] = true
+		}
+		for _, code := range doc.Composition {
+			if !inDeck[code] {
+				return fmt.Errorf("%w: card %s of the composition is neither remaining nor drawn", ErrInvalidImport, code)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ImportDeck creates a deck from doc after validating it. The deck keeps the
+// ID of the document unless that ID is already taken, and starts a new
+// history: a create event with every card, then a draw of the drawn cards.
+// An imported models.RevealOwnerOnly deck is owned by ownerToken.
+func ImportDeck(s store.DeckStore, doc DeckExport, ownerToken string) (models.Deck, error) {
+	if err := ValidateExport(doc); err != nil {
+		return models.Deck{}, err
+	}
+
+	deckID := doc.DeckID
+	if deckID == "" {
+		deckID = uuid.New().String()
+	} else if _, err := s.Get(deckID); err == nil {
+		deckID = uuid.New().String()
+	} else if !errors.Is(err, store.ErrDeckNotFound) {
+		return models.Deck{}, err
+	}
+
+	// The drawn cards go on top, so that drawing them leaves the remaining
+	// cards in order.
+	cards, err := cardsForCodes(deckID, append(append([]string{}, doc.Drawn...), doc.Remaining...))
+	if err != nil {
+		return models.Deck{}, fmt.Errorf("%w: %v", ErrInvalidImport, err)
+	}
+
+	deck := models.Deck{
+		DeckID:       deckID,
+		Shuffled:     doc.Shuffled,
+		ExpiresAt:    doc.ExpiresAt,
+		Cards:        cards,
+		RevealPolicy: doc.RevealPolicy,
+	}
+	if deck.RevealPolicy == "" {
+		deck.RevealPolicy = models.RevealFull
+	}
+	if ownerToken != "" {
+		deck.OwnerTokenHash = models.HashOwnerToken(ownerToken)
+	}
+	// ValidateExport made sure the seed decodes.
+	seed, _ := hex.DecodeString(doc.ShuffleSeed)
+	if len(seed) == 0 {
+		seed = nil
+	}
+	op := models.NewDeckOperation(models.MethodCreate, len(cards), seed, cards)
+	if doc.Seed != nil {
+		op.Seed, op.SeedHash = doc.Seed, models.HashSeed(*doc.Seed)
+	}
+	if err := s.Create(&deck, op); err != nil {
+		return models.Deck{}, err
+	}
+
+	if len(doc.Drawn) == 0 {
+		return deck, nil
+	}
+
+	var drawn []models.Card
+	return s.Update(deckID, store.DrawFunc(len(doc.Drawn), &drawn))
+}
+
+// duplicateCode returns the first code that appears twice in codes, or "".
+func duplicateCode(codes []string) string {
+	seen := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		if seen[code] {
+			return code
+		}
+		seen[code] = true
+	}
+	return ""
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lando-ke/card-api/models"
+)
+
+var (
+	// ErrInvalidAt is returned for a point in a deck's history that does not
+	// exist.
+	ErrInvalidAt = errors.New("invalid point in deck history")
+	// ErrCannotReplay is returned when a deck cannot be rebuilt from its
+	// events, such as for decks recorded before events held their cards.
+	ErrCannotReplay = errors.New("deck cannot be rebuilt from its events")
+)
+
+// EventsAt returns the events of a deck up to at, which is either an event
+// number or an RFC 3339 timestamp. events must be in order.
+func EventsAt(events []models.DeckOperation, at string) ([]models.DeckOperation, error) {
+	if seq, err := strconv.Atoi(at); err == nil {
+		if seq < 1 || seq > len(events) {
+			return nil, fmt.Errorf("%w: deck has no event %d", ErrInvalidAt, seq)
+		}
+		for i, event := range events {
+			if event.Seq == seq {
+				return events[:i+1], nil
+			}
+		}
+		return nil, fmt.Errorf("%w: deck has no event %d", ErrInvalidAt, seq)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q is neither an event number nor an RFC 3339 timestamp", ErrInvalidAt, at)
+	}
+
+	n := 0
+	for n < len(events) && !events[n].CreatedAt.After(t) {
+		n++
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%w: deck did not exist at %s", ErrInvalidAt, at)
+	}
+
+	return events[:n], nil
+}
+
+// ReplayDeck rebuilds the deck deckID as it was after the last of events.
+// Every event is checked against the fingerprint it was recorded with.
+func ReplayDeck(deckID string, events []models.DeckOperation) (models.Deck, error) {
+	states, err := replayStates(deckID, events)
+	if err != nil {
+		return models.Deck{}, err
+	}
+
+	deck := states[len(states)-1].deck
+	deck.Cards = append([]models.Card{}, deck.Cards...)
+	for i := range deck.Cards {
+		deck.Cards[i].Position = i
+	}
+	deck.Remaining = len(deck.Cards)
+	deck.Version = len(events)
+	deck.UpdatedAt = events[len(events)-1].CreatedAt
+
+	return deck, nil
+}
+
+// replayState is the deck as it was after an event that is still in effect,
+// that is, one that has not been undone.
+type replayState struct {
+	deck  models.Deck
+	event models.DeckOperation
+	// rank counts the events before this one that are not undos.
+	rank int
+}
+
+// replayStates replays events and returns the state after each event still
+// in effect, oldest first. The first state is always the deck's creation.
+func replayStates(deckID string, events []models.DeckOperation) ([]replayState, error) {
+	if len(events) == 0 || events[0].Method != models.MethodCreate {
+		return nil, fmt.Errorf("%w: history does not start with a create", ErrCannotReplay)
+	}
+
+	states := []replayState{}
+	rank := 0
+	for _, event := range events {
+		if event.Cards == "" && event.Count > 0 && event.Method != models.MethodUndo {
+			return nil, fmt.Errorf("%w: event %d has no cards", ErrCannotReplay, event.Seq)
+		}
+
+		codes := []string{}
+		if event.Cards != "" {
+			codes = strings.Split(event.Cards, ",")
+		}
+
+		deck := models.Deck{DeckID: deckID}
+		if len(states) > 0 {
+			deck = states[len(states)-1].deck
+		}
+
+		switch event.Method {
+		case models.MethodUndo:
+			if event.Count >= len(states) {
+				return nil, fmt.Errorf("%w: event %d undoes more than the deck's history", ErrCannotReplay, event.Seq)
+			}
+			states = states[:len(states)-event.Count]
+			deck = states[len(states)-1].deck
+		case models.MethodDraw:
+			drawn := make(map[string]bool, len(codes))
+			for _, code := range codes {
+				drawn[code] = true
+			}
+			remaining := []models.Card{}
+			for _, card := range deck.Cards {
+				if !drawn[card.Code] {
+					remaining = append(remaining, card)
+				}
+			}
+			deck.Cards = remaining
+		default:
+			cards, err := cardsForCodes(deckID, codes)
+			if err != nil {
+				return nil, fmt.Errorf("%w: event %d has %v", ErrCannotReplay, event.Seq, err)
+			}
+			deck.Cards = cards
+		}
+
+		switch event.Method {
+		case models.MethodCreate:
+			deck.Shuffled = event.Seeded() || event.Shuffled
+			deck.CreatedAt = event.CreatedAt
+		case models.MethodShuffle:
+			deck.Shuffled = true
+		case models.MethodRestore:
+			deck.Shuffled = event.Shuffled
+		}
+
+		if models.OrderFingerprint(deck.Cards) != event.Fingerprint {
+			return nil, fmt.Errorf("%w: event %d does not match its fingerprint", ErrCannotReplay, event.Seq)
+		}
+
+		if event.Method != models.MethodUndo {
+			states = append(states, replayState{deck: deck, event: event, rank: rank})
+			rank++
+		}
+	}
+
+	return states, nil
+}
+
+// cardsForCodes returns the cards of deckID with the given codes, in order.
+func cardsForCodes(deckID string, codes []string) ([]models.Card, error) {
+	codeToCard := cardsByCode()
+	// Full decks have always used the first letter of the value as code.
+	for _, card := range CreateFullDeck() {
+		codeToCard[card.Code] = card
+	}
+
+	cards := make([]models.Card, 0, len(codes))
+	for _, code := range codes {
+		card, ok := codeToCard[code]
+		if !ok {
+			return nil, fmt.Errorf("unknown card %s", code)
+		}
+		card.DeckID = deckID
+		cards = append(cards, card)
+	}
+
+	return cards, nil
+}
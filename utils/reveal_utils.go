@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"sort"
+
+	"github.com/lando-ke/card-api/models"
+)
+
+// NewOwnerToken returns a random token for the owner of a deck.
+func NewOwnerToken() string {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(token)
+}
+
+// IsOwner reports whether token is the owner token of deck.
+func IsOwner(deck models.Deck, token string) bool {
+	if token == "" || deck.OwnerTokenHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(models.HashOwnerToken(token)), []byte(deck.OwnerTokenHash)) == 1
+}
+
+// OrderVisible reports whether a client holding token may see the draw
+// order of the remaining cards of deck.
+func OrderVisible(deck models.Deck, token string) bool {
+	switch deck.RevealPolicy {
+	case models.RevealFull, "":
+		return true
+	case models.RevealOwnerOnly:
+		return IsOwner(deck, token)
+	default:
+		return false
+	}
+}
+
+// RevealedCards returns the remaining cards of deck as far as its reveal
+// policy lets a client holding token see them: in draw order, sorted like a
+// new deck, or none at all.
+func RevealedCards(deck models.Deck, token string) []models.Card {
+	if OrderVisible(deck, token) {
+		return deck.Cards
+	}
+	if deck.RevealPolicy != models.RevealCompositionOnly {
+		return nil
+	}
+
+	cards := append([]models.Card{}, deck.Cards...)
+	sort.SliceStable(cards, func(i, j int) bool {
+		return cardRank(cards[i]) < cardRank(cards[j])
+	})
+	return cards
+}
+
+// cardRank orders cards by suit, then by value, like an unshuffled full
+// deck.
+func cardRank(card models.Card) int {
+	return indexOf(suits, card.Suit)*len(values) + indexOf(values, card.Value)
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return len(list)
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/store"
+)
+
+var ErrInvalidShuffle = errors.New("invalid shuffle")
+
+// ShuffleOptions restricts a shuffle to part of the cards. The zero value
+// shuffles every card.
+type ShuffleOptions struct {
+	// Top shuffles only the first Top cards, the ones drawn next.
+	Top int
+	// Bottom shuffles only the last Bottom cards.
+	Bottom int
+	// Pinned lists card codes that keep their positions.
+	Pinned []string
+}
+
+// ShuffleSeedSize is the size of the seeds of ShuffleCards.
+const ShuffleSeedSize = 32
+
+// NewShuffleSeed returns a random seed for ShuffleCards.
+func NewShuffleSeed() []byte {
+	seed := make([]byte, ShuffleSeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		panic(err)
+	}
+	return seed
+}
+
+// ShuffleCards shuffles cards in place. The same seed and options always
+// produce the same order, which lets an audited shuffle be reproduced.
+//
+// The seed keys AES-256 in counter mode, whose output drives a Fisher-Yates
+// shuffle. With 256 bits of seed every order of a deck can come out, and the
+// cards drawn so far tell nothing that helps find the seed.
+func ShuffleCards(cards []models.Card, seed []byte, opts ShuffleOptions) ([]models.Card, error) {
+	if len(seed) != ShuffleSeedSize {
+		return nil, fmt.Errorf("%w: seed must be %d bytes long", ErrInvalidShuffle, ShuffleSeedSize)
+	}
+	positions, err := shufflePositions(cards, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newSeededStream(seed)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(positions) - 1; i > 0; i-- {
+		a, b := positions[i], positions[r.intn(i+1)]
+		cards[a], cards[b] = cards[b], cards[a]
+	}
+
+	return cards, nil
+}
+
+// seededStream is a deterministic stream of random numbers, the AES-256-CTR
+// key stream of a seed.
+type seededStream struct {
+	ctr cipher.Stream
+	buf [8]byte
+}
+
+func newSeededStream(seed []byte) (*seededStream, error) {
+	block, err := aes.NewCipher(seed)
+	if err != nil {
+		return nil, err
+	}
+	return &seededStream{ctr: cipher.NewCTR(block, make([]byte, aes.BlockSize))}, nil
+}
+
+func (r *seededStream) uint64() uint64 {
+	r.buf = [8]byte{}
+	r.ctr.XORKeyStream(r.buf[:], r.buf[:])
+	return binary.LittleEndian.Uint64(r.buf[:])
+}
+
+// intn returns a number in [0, n). Numbers past the last whole multiple of
+// n are drawn again, so that every result is equally likely.
+func (r *seededStream) intn(n int) int {
+	limit := ^uint64(0) - ^uint64(0)%uint64(n)
+	for {
+		if v := r.uint64(); v < limit {
+			return int(v % uint64(n))
+		}
+	}
+}
+
+// shufflePositions returns the indexes of cards that opts allows to move.
+func shufflePositions(cards []models.Card, opts ShuffleOptions) ([]int, error) {
+	if opts.Top < 0 || opts.Bottom < 0 {
+		return nil, fmt.Errorf("%w: top and bottom cannot be negative", ErrInvalidShuffle)
+	}
+	if opts.Top > 0 && opts.Bottom > 0 {
+		return nil, fmt.Errorf("%w: top and bottom cannot be combined", ErrInvalidShuffle)
+	}
+	if opts.Top > len(cards) || opts.Bottom > len(cards) {
+		return nil, fmt.Errorf("%w: only %d cards in deck", ErrInvalidShuffle, len(cards))
+	}
+
+	start, end := 0, len(cards)
+	if opts.Top > 0 {
+		end = opts.Top
+	}
+	if opts.Bottom > 0 {
+		start = len(cards) - opts.Bottom
+	}
+
+	pinned := make(map[string]bool)
+	for _, code := range opts.Pinned {
+		pinned[strings.ToUpper(code)] = false
+	}
+	for _, card := range cards {
+		if _, ok := pinned[card.Code]; ok {
+			pinned[card.Code] = true
+		}
+	}
+	for code, found := range pinned {
+		if !found {
+			return nil, fmt.Errorf("%w: pinned card %s is not in the deck", ErrInvalidShuffle, code)
+		}
+	}
+
+	positions := []int{}
+	for i := start; i < end; i++ {
+		if _, ok := pinned[cards[i].Code]; !ok {
+			positions = append(positions, i)
+		}
+	}
+
+	return positions, nil
+}
+
+// ShuffleDeck shuffles the remaining cards of a deck according to opts and
+// records the shuffle in the deck's history.
+func ShuffleDeck(s store.DeckStore, deckID string, opts ShuffleOptions) (models.Deck, error) {
+	return s.Update(deckID, ShuffleFunc(opts))
+}
+
+// ShuffleFunc returns an UpdateFunc that shuffles the remaining cards of a
+// deck according to opts.
+func ShuffleFunc(opts ShuffleOptions) store.UpdateFunc {
+	return func(deck *models.Deck) (models.DeckOperation, error) {
+		positions, err := shufflePositions(deck.Cards, opts)
+		if err != nil {
+			return models.DeckOperation{}, err
+		}
+
+		seed := NewShuffleSeed()
+		if deck.Cards, err = ShuffleCards(deck.Cards, seed, opts); err != nil {
+			return models.DeckOperation{}, err
+		}
+
+		deck.Shuffled = true
+		return models.NewDeckOperation(models.MethodShuffle, len(positions), seed, deck.Cards), nil
+	}
+}
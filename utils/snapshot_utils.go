@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/store"
+)
+
+// ErrInvalidSnapshotName is returned for a snapshot name that is empty, too
+// long or not safe to use in a URL path.
+var ErrInvalidSnapshotName = errors.New("snapshot names must be 1 to 64 letters, digits, '.', '_' or '-'")
+
+var snapshotName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// SaveSnapshot saves the current state of a deck under name, replacing any
+// snapshot of the deck with that name.
+func SaveSnapshot(s store.DeckStore, deckID, name string) (models.DeckSnapshot, error) {
+	if !snapshotName.MatchString(name) {
+		return models.DeckSnapshot{}, ErrInvalidSnapshotName
+	}
+
+	deck, err := s.Get(deckID)
+	if err != nil {
+		return models.DeckSnapshot{}, err
+	}
+	history, err := s.History(deckID)
+	if err != nil {
+		return models.DeckSnapshot{}, err
+	}
+
+	// The drawn cards are the cards the deck was created with that it no
+	// longer holds.
+	live := make(map[string]bool, len(deck.Cards))
+	for _, card := range deck.Cards {
+		live[card.Code] = true
+	}
+	drawn := []string{}
+	if len(history) > 0 && history[0].Cards != "" {
+		for _, code := range strings.Split(history[0].Cards, ",") {
+			if !live[code] {
+				drawn = append(drawn, code)
+			}
+		}
+	}
+
+	snapshot := models.DeckSnapshot{
+		DeckID:      deckID,
+		Name:        name,
+		Version:     deck.Version,
+		Shuffled:    deck.Shuffled,
+		Remaining:   len(deck.Cards),
+		Cards:       strings.Join(models.CardCodes(deck.Cards), ","),
+		Drawn:       strings.Join(drawn, ","),
+		Fingerprint: models.OrderFingerprint(deck.Cards),
+	}
+	if err := s.SaveSnapshot(&snapshot); err != nil {
+		return models.DeckSnapshot{}, err
+	}
+
+	return snapshot, nil
+}
+
+// RestoreFunc returns an UpdateFunc that puts a deck back in the state saved
+// in snapshot: drawn cards return to the deck and the order is restored.
+func RestoreFunc(snapshot models.DeckSnapshot) (store.UpdateFunc, error) {
+	codes := []string{}
+	if snapshot.Cards != "" {
+		codes = strings.Split(snapshot.Cards, ",")
+	}
+	cards, err := cardsForCodes(snapshot.DeckID, codes)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s has %v", snapshot.Name, err)
+	}
+
+	return func(deck *models.Deck) (models.DeckOperation, error) {
+		deck.Cards = append([]models.Card{}, cards...)
+		deck.Shuffled = snapshot.Shuffled
+		return models.NewDeckOperation(models.MethodRestore, len(cards), nil, deck.Cards), nil
+	}, nil
+}
+
+// RestoreSnapshot restores a deck to its snapshot called name.
+func RestoreSnapshot(s store.DeckStore, deckID, name string) (models.Deck, error) {
+	snapshot, err := s.Snapshot(deckID, name)
+	if err != nil {
+		return models.Deck{}, err
+	}
+
+	fn, err := RestoreFunc(snapshot)
+	if err != nil {
+		return models.Deck{}, err
+	}
+
+	return s.Update(deckID, fn)
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lando-ke/card-api/models"
+	"github.com/lando-ke/card-api/store"
+)
+
+// ErrInvalidUndo is returned for an undo that reaches back further than the
+// deck's history or the undo depth allows.
+var ErrInvalidUndo = errors.New("invalid undo")
+
+// UndoFunc returns an UpdateFunc that undoes the last steps operations of a
+// deck with the given history, restoring its cards and shuffled state. Only
+// the depth most recent operations can be undone, and the creation of a deck
+// never can. version is the version of the deck read before its history; the
+// function fails with a VersionMismatchError when the deck has changed since.
+func UndoFunc(history []models.DeckOperation, version, steps, depth int) (store.UpdateFunc, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("%w: steps must be at least 1", ErrInvalidUndo)
+	}
+	if len(history) == 0 {
+		return nil, fmt.Errorf("%w: deck has no history", ErrInvalidUndo)
+	}
+
+	states, err := replayStates(history[0].DeckID, history)
+	if err != nil {
+		return nil, err
+	}
+
+	operations := 0
+	for _, event := range history {
+		if event.Method != models.MethodUndo {
+			operations++
+		}
+	}
+
+	undoable := 0
+	for i := len(states) - 1; i > 0 && states[i].rank >= operations-depth; i-- {
+		undoable++
+	}
+	if steps > undoable {
+		return nil, fmt.Errorf("%w: only %d operations can be undone", ErrInvalidUndo, undoable)
+	}
+
+	target := states[len(states)-1-steps].deck
+
+	return store.IfVersion([]int{version}, func(deck *models.Deck) (models.DeckOperation, error) {
+		deck.Cards = append([]models.Card{}, target.Cards...)
+		deck.Shuffled = target.Shuffled
+		return models.NewDeckOperation(models.MethodUndo, steps, nil, deck.Cards), nil
+	}), nil
+}
+
+// UndoDeck undoes the last steps operations of a deck, as UndoFunc does.
+func UndoDeck(s store.DeckStore, deckID string, steps, depth int) (models.Deck, error) {
+	deck, err := s.Get(deckID)
+	if err != nil {
+		return models.Deck{}, err
+	}
+	history, err := s.History(deckID)
+	if err != nil {
+		return models.Deck{}, err
+	}
+
+	fn, err := UndoFunc(history, deck.Version, steps, depth)
+	if err != nil {
+		return models.Deck{}, err
+	}
+
+	return s.Update(deckID, fn)
+}